@@ -17,6 +17,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -26,14 +28,34 @@ import (
 
 // main is the entrypoint of the exporter
 func main() {
+	configPath := flag.String("config", "", "Path to the YAML config file, overrides the environment variables")
+	webConfigFile := flag.String("web.config.file", "", "Path to the web config file enabling TLS, overrides the config file")
+	flag.Parse()
 
 	// Initialize exporter
-	go exporter.Init()
-
-	// Wait for shutdown signal and stop gracefully
+	go exporter.Init(*configPath, *webConfigFile)
+
+	// Reload the configuration on SIGHUP. A signal during startup is held back until Init is done with
+	// the configuration, so a reload never runs against a half-initialized exporter.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		<-exporter.Started
+		for range reload {
+			exporter.Reload()
+		}
+	}()
+
+	// Wait for shutdown signal or a completed drain and stop gracefully
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	defer stop()
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+		stop() // A second signal exits immediately
+		log.Printf("Received shutdown signal, draining")
+		exporter.Shutdown()
+	case <-exporter.Quit:
+	}
 	stop()
 
 	// test
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DefaultEnvPrefix = "NUTANIX"
+
+// CredentialProvider supplies the username and password of Nutanix clusters
+type CredentialProvider interface {
+	// GetCredentials returns the username and password of the named cluster
+	GetCredentials(cluster string) (string, string, error)
+	// RefreshIfNeeded renews the provider's own access to its secret store, if it has expired
+	RefreshIfNeeded() error
+}
+
+// VaultProvider reads credentials from the Vault KV V2 engine
+type VaultProvider struct {
+	Client  *VaultClient
+	Account string // Secret name below the cluster name, e.g. PETaskAccount
+	Path    string // Optional fixed secret path, overrides <cluster>/<Account>
+}
+
+// GetCredentials reads the credentials of the cluster from Vault
+func (p *VaultProvider) GetCredentials(cluster string) (string, string, error) {
+	if p.Path != "" {
+		return p.Client.GetCredsFromPath(p.Path, EngineName)
+	}
+	return p.Client.GetCreds(cluster, p.Account, EngineName)
+}
+
+// RefreshIfNeeded logs in to Vault again if the current token is no longer valid
+func (p *VaultProvider) RefreshIfNeeded() error {
+	if err := p.Client.Ping(); err == nil {
+		return nil
+	}
+	log.Printf("Vault token is no longer valid, logging in again")
+	return p.Client.Login()
+}
+
+// FileProvider reads credentials from files, e.g. a Kubernetes Secret mounted as a directory
+// The files are read on every call, so rotated secrets are picked up without a restart
+type FileProvider struct {
+	Dir string // Holds username and password files, or one such directory per cluster name
+}
+
+// GetCredentials reads the username and password files of the cluster
+// <Dir>/<cluster>/ is used if it exists, otherwise <Dir>/
+func (p *FileProvider) GetCredentials(cluster string) (string, string, error) {
+	dir := p.Dir
+	if info, err := os.Stat(filepath.Join(p.Dir, cluster)); err == nil && info.IsDir() {
+		dir = filepath.Join(p.Dir, cluster)
+	}
+
+	username, err := os.ReadFile(filepath.Join(dir, "username"))
+	if err != nil {
+		return "", "", err
+	}
+	password, err := os.ReadFile(filepath.Join(dir, "password"))
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), nil
+}
+
+// RefreshIfNeeded is a no-op, the files are read on every call
+func (p *FileProvider) RefreshIfNeeded() error {
+	return nil
+}
+
+// EnvProvider reads credentials from environment variables
+type EnvProvider struct {
+	Prefix string // Defaults to DefaultEnvPrefix
+}
+
+// GetCredentials reads <PREFIX>_<CLUSTER>_USERNAME and _PASSWORD, falling back to <PREFIX>_USERNAME and _PASSWORD
+// The cluster name is upper-cased and every character other than letters and digits replaced with _
+func (p *EnvProvider) GetCredentials(cluster string) (string, string, error) {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+
+	clusterPrefix := prefix + "_" + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(cluster))
+
+	for _, p := range []string{clusterPrefix, prefix} {
+		username, password := os.Getenv(p+"_USERNAME"), os.Getenv(p+"_PASSWORD")
+		if username != "" && password != "" {
+			return username, password, nil
+		}
+	}
+	return "", "", fmt.Errorf("no credentials for %s in %s_USERNAME or %s_USERNAME", cluster, clusterPrefix, prefix)
+}
+
+// RefreshIfNeeded is a no-op, the environment is read on every call
+func (p *EnvProvider) RefreshIfNeeded() error {
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault-client-go"
@@ -39,7 +40,13 @@ var (
 
 // VaultClient is a wrapper around the Vault client
 type VaultClient struct {
+	mu     sync.RWMutex
 	client *vault.Client
+
+	addr      string
+	roleId    string
+	secretId  string
+	namespace string
 }
 
 // getEnvOrFatal returns the value of the specified environment variable or exits the program
@@ -54,16 +61,30 @@ func getEnvOrFatal(envVar string) string {
 // NewVaultClient creates a new Vault client and authenticates using AppRole
 // Uses the VAULT_ADDR, VAULT_ROLE_ID, VAULT_SECRET_ID and VAULT_NAMESPACE environment variables
 func NewVaultClient() (*VaultClient, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
-	defer cancel()
-
-	addr := getEnvOrFatal("VAULT_ADDR")
-	roleId := getEnvOrFatal("VAULT_ROLE_ID")
-	secretId := getEnvOrFatal("VAULT_SECRET_ID")
+	v := &VaultClient{
+		addr:      getEnvOrFatal("VAULT_ADDR"),
+		roleId:    getEnvOrFatal("VAULT_ROLE_ID"),
+		secretId:  getEnvOrFatal("VAULT_SECRET_ID"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
 	PETaskAccount = getEnvOrFatal("PE_TASK_ACCOUNT")
 	PCTaskAccount = getEnvOrFatal("PC_TASK_ACCOUNT")
 	EngineName = getEnvOrFatal("VAULT_ENGINE_NAME")
-	namespace := os.Getenv("VAULT_NAMESPACE")
+
+	if err := v.Login(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Login authenticates using AppRole and replaces the underlying client
+// Credential providers keep a reference to the VaultClient, so logging in again doesn't disturb them.
+// If the login fails, the client of the previous login is kept.
+func (v *VaultClient) Login() error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	addr, roleId, secretId, namespace := v.addr, v.roleId, v.secretId, v.namespace
 
 	log.Printf("Creating new Vault client for %s", addr)
 	client, err := vault.New(
@@ -71,7 +92,7 @@ func NewVaultClient() (*VaultClient, error) {
 		vault.WithRequestTimeout(Timeout),
 	)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to create Vault client: %v", err)
 	}
 
 	log.Printf("Authenticating with Vault using AppRole")
@@ -98,24 +119,63 @@ func NewVaultClient() (*VaultClient, error) {
 	}
 
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("AppRole login failed: %v", err)
 	}
 
 	log.Printf("Setting token for Vault client")
 	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to set Vault token: %v", err)
 	}
 
 	if namespace != "" {
 		log.Printf("Setting namespace to %s", namespace)
 		if err = client.SetNamespace(namespace); err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("failed to set Vault namespace: %v", err)
 		}
 	} else {
 		log.Printf("No namespace specified")
 	}
 
-	return &VaultClient{client: client}, nil
+	v.mu.Lock()
+	v.client = client
+	v.mu.Unlock()
+	return nil
+}
+
+// current returns the client of the latest login
+func (v *VaultClient) current() *vault.Client {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.client
+}
+
+// Ping checks that Vault is reachable and the client token is still valid
+func (v *VaultClient) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	_, err := v.current().Auth.TokenLookUpSelf(ctx)
+	return err
+}
+
+// TokenTTL returns the remaining lifetime of the client token
+func (v *VaultClient) TokenTTL() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	resp, err := v.current().Auth.TokenLookUpSelf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	ttl, ok := resp.Data["ttl"].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("token lookup returned no ttl")
+	}
+	seconds, err := ttl.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("invalid token ttl %q: %v", ttl, err)
+	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 // GetSecret reads a secret from Vault using KV V2 secrets engine
@@ -124,7 +184,7 @@ func (v *VaultClient) GetSecret(path, engine string) (string, error) {
 	defer cancel()
 
 	// Read the secret from the specified path using KV V2
-	vaultResponse, err := v.client.Secrets.KvV2Read(ctx, path, vault.WithMountPath(engine))
+	vaultResponse, err := v.current().Secrets.KvV2Read(ctx, path, vault.WithMountPath(engine))
 	if err != nil {
 		return "", err
 	}
@@ -151,9 +211,15 @@ func (v *VaultClient) GetPECreds(cluster string) (string, string, error) {
 // GetCreds returns the username and password for the specified cluster, path, and engine
 // Returns error if the credentials cannot be retrieved or parsed
 func (v *VaultClient) GetCreds(cluster, path, engine string) (string, string, error) {
-	secrets, err := v.GetSecret(fmt.Sprintf("%s/%s", cluster, path), engine)
+	return v.GetCredsFromPath(fmt.Sprintf("%s/%s", cluster, path), engine)
+}
+
+// GetCredsFromPath returns the username and password stored in the secret at the specified path and engine
+// Returns error if the credentials cannot be retrieved or parsed
+func (v *VaultClient) GetCredsFromPath(path, engine string) (string, string, error) {
+	secrets, err := v.GetSecret(path, engine)
 	if err != nil {
-		log.Printf("Warning: Failed to get secrets for %s: %v", cluster, err)
+		log.Printf("Warning: Failed to get secrets for %s: %v", path, err)
 		return "", "", err
 	}
 
@@ -162,9 +228,8 @@ func (v *VaultClient) GetCreds(cluster, path, engine string) (string, string, er
 		Secret   string `json:"secret"`
 	}
 	if err := json.Unmarshal([]byte(secrets), &vaultSecret); err != nil {
-		log.Printf("Warning: Failed to parse secrets for %s: %v", cluster, err)
+		log.Printf("Warning: Failed to parse secrets for %s: %v", path, err)
 		return "", "", err
 	}
 	return vaultSecret.Username, vaultSecret.Secret, nil
 }
-
@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultGroupMetrics are the metrics aggregated for a group when it does not list its own
+var DefaultGroupMetrics = []string{
+	"nutanix_cluster_num_nodes",
+	"nutanix_host_cpu_capacity_in_hz",
+	"nutanix_host_memory_capacity_in_bytes",
+	"nutanix_host_usage_stats_storage_capacity_bytes",
+	"nutanix_host_usage_stats_storage_free_bytes",
+	"nutanix_host_num_vms",
+	"nutanix_storage_container_usage_stats_storage_usage_bytes",
+	"nutanix_vm_grand_total_entities",
+}
+
+// ClusterGroup is a named set of clusters whose metrics are served pre-aggregated
+type ClusterGroup struct {
+	Name     string   `yaml:"name"`
+	Clusters []string `yaml:"clusters"` // Cluster names or glob patterns, e.g. "emea-*"
+	Metrics  []string `yaml:"metrics"`  // Metric names to aggregate, defaults to DefaultGroupMetrics
+}
+
+// LoadGroups reads the cluster group definitions from the given YAML file
+func LoadGroups(configPath string) (map[string]*ClusterGroup, error) {
+	yamlFile, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var groupList []*ClusterGroup
+	if err := yaml.Unmarshal(yamlFile, &groupList); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*ClusterGroup)
+	for _, g := range groupList {
+		if g.Name == "" {
+			return nil, fmt.Errorf("cluster group without a name in %s", configPath)
+		}
+		if _, exists := groups[g.Name]; exists {
+			return nil, fmt.Errorf("duplicate cluster group %s in %s", g.Name, configPath)
+		}
+		if len(g.Metrics) == 0 {
+			g.Metrics = DefaultGroupMetrics
+		}
+		groups[g.Name] = g
+		log.Printf("Loaded cluster group %s with %d cluster pattern(s)", g.Name, len(g.Clusters))
+	}
+
+	return groups, nil
+}
+
+// groupCollector aggregates the metrics of all clusters in a group at collect time
+type groupCollector struct {
+	group *ClusterGroup
+	ctx   context.Context // Context of the request, collections are not waited for after it is done
+}
+
+// Describe is intentionally empty, the aggregated metrics depend on what the clusters return
+func (c *groupCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect gathers every cluster in the group and emits the sum and per-cluster average of each metric
+func (c *groupCollector) Collect(ch chan<- prometheus.Metric) {
+	clusters := clustersMatching(c.group.Clusters)
+	gathered := gatherClusters(c.ctx, clusters)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("nutanix_group_clusters", "Number of clusters in the group.", []string{"group"}, nil),
+		prometheus.GaugeValue, float64(len(clusters)), c.group.Name,
+	)
+
+	for _, metricName := range c.group.Metrics {
+		var sum float64
+		var reporting int
+		for _, families := range gathered {
+			if value, ok := sumMetric(families, metricName); ok {
+				sum += value
+				reporting++
+			}
+		}
+		if reporting == 0 {
+			continue
+		}
+
+		name := strings.TrimPrefix(metricName, "nutanix_")
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("nutanix_group_"+name+"_sum", fmt.Sprintf("Sum of %s across the clusters in the group.", metricName), []string{"group"}, nil),
+			prometheus.GaugeValue, sum, c.group.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("nutanix_group_"+name+"_avg", fmt.Sprintf("Per-cluster average of %s across the clusters in the group.", metricName), []string{"group"}, nil),
+			prometheus.GaugeValue, sum/float64(reporting), c.group.Name,
+		)
+	}
+}
+
+// groupMetricsHandler serves /metrics/group/<group> with metrics aggregated across the group's clusters
+func groupMetricsHandler(groups map[string]*ClusterGroup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group, ok := groups[strings.TrimPrefix(r.URL.Path, "/metrics/group/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&groupCollector{group: group, ctx: r.Context()})
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// clustersMatching returns the clusters whose names match any of the given names or glob patterns
+func clustersMatching(patterns []string) []*nutanix.Cluster {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	var clusters []*nutanix.Cluster
+	for _, cluster := range ClustersMap {
+		if matchesAny(patterns, cluster.Name) {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// gatherClusters concurrently gathers the metric families of every given cluster, keyed by cluster ID
+// Clusters that fail to gather are logged and left out of the result
+func gatherClusters(ctx context.Context, clusters []*nutanix.Cluster) map[string][]*dto.MetricFamily {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	gathered := make(map[string][]*dto.MetricFamily)
+
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster *nutanix.Cluster) {
+			defer wg.Done()
+			cluster.RefreshCredentialsIfNeeded()
+
+			families, _, err := gatherCluster(ctx, cluster)
+			if err != nil {
+				log.Printf("Error gathering metrics for cluster %s: %v", cluster.Name, err)
+				return
+			}
+			mu.Lock()
+			gathered[cluster.ID()] = families
+			mu.Unlock()
+		}(cluster)
+	}
+	wg.Wait()
+
+	return gathered
+}
+
+// sumMetric sums every sample of the named metric family
+// Returns false if the family is not present
+func sumMetric(families []*dto.MetricFamily, name string) (float64, bool) {
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		var sum float64
+		for _, m := range family.GetMetric() {
+			sum += sampleValue(m)
+		}
+		return sum, true
+	}
+	return 0, false
+}
+
+// sampleValue returns the value of a gauge, counter or untyped sample
+func sampleValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetUntyped() != nil:
+		return m.GetUntyped().GetValue()
+	}
+	return 0
+}
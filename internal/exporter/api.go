@@ -0,0 +1,53 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// ClusterSummary is the JSON representation of a cluster served by the exporter
+type ClusterSummary struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid,omitempty"`
+	URL  string `json:"url"`
+}
+
+// clustersAPIHandler serves /api/clusters with the list of clusters served by the exporter
+func clustersAPIHandler(w http.ResponseWriter, r *http.Request) {
+	clustersMu.RLock()
+	updatedAt := clustersUpdatedAt
+	clusters := make([]ClusterSummary, 0, len(ClustersMap))
+	for _, cluster := range ClustersMap {
+		clusters = append(clusters, ClusterSummary{Name: cluster.Name, UUID: cluster.UUID, URL: cluster.URL})
+	}
+	clustersMu.RUnlock()
+
+	// The list only changes on cluster refresh, so pollers can revalidate instead of refetching
+	if checkNotModified(w, r, updatedAt, 0) {
+		return
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clusters); err != nil {
+		log.Printf("Error encoding cluster list: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+var blackoutActiveDesc = prometheus.NewDesc(
+	"nutanix_exporter_blackout_active",
+	"Whether collection of the cluster is suppressed by a blackout window (1) or not (0).",
+	[]string{"cluster_name", "cluster_uuid"}, nil,
+)
+
+// Blackout is a recurring window during which collection of the matching clusters is suppressed
+type Blackout struct {
+	Clusters []string      `yaml:"clusters"` // Cluster names or glob patterns, e.g. "store-*"
+	Schedule string        `yaml:"schedule"` // Start of the window in cron format, e.g. "0 2 * * *"
+	Duration time.Duration `yaml:"duration"` // Length of the window, e.g. "2h"
+	Timezone string        `yaml:"timezone"` // Optional, defaults to the local timezone
+
+	schedule *cronSchedule
+	location *time.Location
+}
+
+// LoadBlackouts reads the blackout windows from the given YAML file
+func LoadBlackouts(configPath string) ([]*Blackout, error) {
+	yamlFile, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var blackouts []*Blackout
+	if err := yaml.Unmarshal(yamlFile, &blackouts); err != nil {
+		return nil, err
+	}
+
+	for _, b := range blackouts {
+		if b.Duration <= 0 {
+			return nil, fmt.Errorf("blackout %q without a duration in %s", b.Schedule, configPath)
+		}
+		if b.schedule, err = parseCron(b.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid blackout schedule %q in %s: %v", b.Schedule, configPath, err)
+		}
+		b.location = time.Local
+		if b.Timezone != "" {
+			if b.location, err = time.LoadLocation(b.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid blackout timezone %q in %s: %v", b.Timezone, configPath, err)
+			}
+		}
+		log.Printf("Loaded blackout window %q for %s with %d cluster pattern(s)", b.Schedule, b.Duration, len(b.Clusters))
+	}
+
+	return blackouts, nil
+}
+
+// ActiveAt reports whether a window of the blackout started within its duration before t
+func (b *Blackout) ActiveAt(t time.Time) bool {
+	t = t.In(b.location).Truncate(time.Minute)
+	start, ok := b.schedule.prev(t, t.Add(-b.Duration))
+	return ok && t.Sub(start) < b.Duration
+}
+
+// blackoutActive reports whether collection of the cluster is currently suppressed
+func blackoutActive(cluster *nutanix.Cluster) bool {
+	now := time.Now()
+	for _, b := range current().Blackouts {
+		if matchesAny(b.Clusters, cluster.Name) && b.ActiveAt(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// blackoutCollector exposes whether a cluster is in a blackout window
+type blackoutCollector struct {
+	cluster *nutanix.Cluster
+}
+
+// Describe method required by prometheus.Collector interface
+func (c *blackoutCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- blackoutActiveDesc
+}
+
+// Collect method required by prometheus.Collector interface
+func (c *blackoutCollector) Collect(ch chan<- prometheus.Metric) {
+	value := 0.0
+	if blackoutActive(c.cluster) {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(blackoutActiveDesc, prometheus.GaugeValue, value, c.cluster.Name, c.cluster.UUID)
+}
+
+// cronSchedule is a parsed five-field cron expression, each field a bitmask of the allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // Unrestricted day fields, see matches
+}
+
+// parseCron parses a five-field cron expression (minute hour day-of-month month day-of-week)
+// Fields support *, single values, ranges (1-5), lists (1,15) and steps (*/15, 0-30/10)
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	// Both 0 and 7 are Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField parses one comma-separated cron field into a bitmask of values between min and max
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			rangePart = part[:i]
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if i >= 0 {
+				hi = max // "5/15" means every 15 starting at 5
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q", part)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// matches reports whether the minute of t matches the schedule
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 && s.hour&(1<<uint(t.Hour())) != 0 && s.matchesDay(t)
+}
+
+// prev returns the latest minute at or before t that matches the schedule, and false if there is none after earliest
+// Days and hours that don't match are skipped as a whole, so long windows are searched in a few steps
+func (s *cronSchedule) prev(t, earliest time.Time) (time.Time, bool) {
+	for t.After(earliest) {
+		switch {
+		case !s.matchesDay(t):
+			// Continue with the last minute of the previous day
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// Continue with the last minute of the previous hour
+			t = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+		default:
+			for m := t.Minute(); m >= 0; m-- {
+				if s.minute&(1<<uint(m)) != 0 {
+					return t.Add(-time.Duration(t.Minute()-m) * time.Minute), true
+				}
+			}
+			t = t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+		}
+	}
+	return time.Time{}, false
+}
+
+// matchesDay reports whether the day of t matches the schedule
+// As in cron, if both day fields are restricted a day matching either of them matches
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domOk := s.dom&(1<<uint(t.Day())) != 0
+	dowOk := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domOk && dowOk
+	}
+	return domOk || dowOk
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// bits returns the bitmask of the given values
+func bits(values ...int) uint64 {
+	var mask uint64
+	for _, v := range values {
+		mask |= 1 << uint(v)
+	}
+	return mask
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{field: "*", min: 0, max: 6, want: bits(0, 1, 2, 3, 4, 5, 6)},
+		{field: "5", min: 0, max: 59, want: bits(5)},
+		{field: "1-3", min: 0, max: 59, want: bits(1, 2, 3)},
+		{field: "1,15", min: 1, max: 31, want: bits(1, 15)},
+		{field: "*/15", min: 0, max: 59, want: bits(0, 15, 30, 45)},
+		{field: "0-30/10", min: 0, max: 59, want: bits(0, 10, 20, 30)},
+		{field: "5/15", min: 0, max: 59, want: bits(5, 20, 35, 50)},
+		{field: "20/1", min: 0, max: 23, want: bits(20, 21, 22, 23)},
+		{field: "1-5,*/20", min: 0, max: 59, want: bits(0, 1, 2, 3, 4, 5, 20, 40)},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "0", min: 1, max: 31, wantErr: true},
+		{field: "5-1", min: 0, max: 59, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "*/x", min: 0, max: 59, wantErr: true},
+		{field: "a", min: 0, max: 59, wantErr: true},
+		{field: "1-b", min: 0, max: 59, wantErr: true},
+		{field: "", min: 0, max: 59, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.min, tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCronField(%q) error = %v, wantErr %v", tt.field, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "0 2 * * *"},
+		{expr: "*/15 0-6 1,15 * 1-5"},
+		{expr: "0 2 * *", wantErr: "expected 5 fields, got 4"},
+		{expr: "0 2 * * * *", wantErr: "expected 5 fields, got 6"},
+		{expr: "60 2 * * *", wantErr: "minute"},
+		{expr: "0 24 * * *", wantErr: "hour"},
+		{expr: "0 2 32 * *", wantErr: "day of month"},
+		{expr: "0 2 * 13 *", wantErr: "month"},
+		{expr: "0 2 * * 8", wantErr: "day of week"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCron(tt.expr)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("parseCron(%q) error = %v", tt.expr, err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("parseCron(%q) error = %v, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2024-06-01 is a Saturday, 2024-06-02 a Sunday and 2024-06-03 a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"every minute", "* * * * *", at(1, 13, 37), true},
+		{"minute and hour", "30 2 * * *", at(1, 2, 30), true},
+		{"other minute", "30 2 * * *", at(1, 2, 31), false},
+		{"step from offset", "5/15 * * * *", at(1, 0, 35), true},
+		{"step from offset before the start", "5/15 * * * *", at(1, 0, 0), false},
+		{"month", "0 0 * 7 *", at(1, 0, 0), false},
+		{"0 is sunday", "0 0 * * 0", at(2, 0, 0), true},
+		{"7 is sunday", "0 0 * * 7", at(2, 0, 0), true},
+		{"7 is not saturday", "0 0 * * 7", at(1, 0, 0), false},
+		{"range ending in 7 includes sunday", "0 0 * * 5-7", at(2, 0, 0), true},
+		{"day of week only", "0 0 * * 1", at(3, 0, 0), true},
+		{"day of week only, other day", "0 0 * * 1", at(1, 0, 0), false},
+		{"day of month only", "0 0 1 * *", at(1, 0, 0), true},
+		{"day of month only, other day", "0 0 1 * *", at(3, 0, 0), false},
+		{"both days restricted, day of month matches", "0 0 1 * 1", at(1, 0, 0), true},
+		{"both days restricted, day of week matches", "0 0 1 * 1", at(3, 0, 0), true},
+		{"both days restricted, neither matches", "0 0 1 * 1", at(2, 0, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.expr, err)
+			}
+			if got := s.matches(tt.t); got != tt.want {
+				t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlackoutActiveAt(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		schedule string
+		duration time.Duration
+		location *time.Location
+		t        time.Time
+		want     bool
+	}{
+		{"at the start", "0 2 * * *", 2 * time.Hour, time.UTC, time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC), true},
+		{"before the start", "0 2 * * *", 2 * time.Hour, time.UTC, time.Date(2024, 6, 1, 1, 59, 59, 0, time.UTC), false},
+		{"within the window", "0 2 * * *", 2 * time.Hour, time.UTC, time.Date(2024, 6, 1, 3, 59, 59, 0, time.UTC), true},
+		{"at the end", "0 2 * * *", 2 * time.Hour, time.UTC, time.Date(2024, 6, 1, 4, 0, 0, 0, time.UTC), false},
+		{"window crossing midnight", "30 23 * * *", time.Hour, time.UTC, time.Date(2024, 6, 2, 0, 15, 0, 0, time.UTC), true},
+		{"window crossing into another weekday", "0 22 * * 6", 4 * time.Hour, time.UTC, time.Date(2024, 6, 2, 1, 0, 0, 0, time.UTC), true},
+		{"weekday after the window", "0 22 * * 6", 4 * time.Hour, time.UTC, time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC), false},
+		// 02:00 in Stockholm is 00:00 UTC in summer
+		{"schedule in the blackout timezone", "0 2 * * *", time.Hour, stockholm, time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC), true},
+		{"utc hour of the schedule", "0 2 * * *", time.Hour, stockholm, time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC), false},
+		// 23:00 on Friday in New York is 03:00 on Saturday UTC, the lookback crosses the day in the blackout timezone
+		{"lookback across the day in the blackout timezone", "0 23 * * 5", 6 * time.Hour, newYork, time.Date(2024, 6, 1, 5, 0, 0, 0, time.UTC), true},
+		{"time given in another timezone", "0 23 * * 5", 6 * time.Hour, newYork, time.Date(2024, 6, 1, 7, 0, 0, 0, stockholm), true},
+		{"after a window in the blackout timezone", "0 23 * * 5", 6 * time.Hour, newYork, time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC), false},
+		{"within a week long window", "30 2 1 * *", 7 * 24 * time.Hour, time.UTC, time.Date(2024, 6, 7, 23, 0, 0, 0, time.UTC), true},
+		{"after a week long window", "30 2 1 * *", 7 * 24 * time.Hour, time.UTC, time.Date(2024, 6, 8, 2, 30, 0, 0, time.UTC), false},
+		{"before the start minute of the hour", "30 2 1 * *", 7 * 24 * time.Hour, time.UTC, time.Date(2024, 6, 1, 2, 29, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.schedule)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.schedule, err)
+			}
+			b := &Blackout{Schedule: tt.schedule, Duration: tt.duration, schedule: s, location: tt.location}
+			if got := b.ActiveAt(tt.t); got != tt.want {
+				t.Errorf("ActiveAt(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
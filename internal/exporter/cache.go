@@ -0,0 +1,440 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var CollectionInterval time.Duration // How often clusters are collected in the background, 0 collects on scrape
+
+var metricsCache = struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}{entries: make(map[string]*cacheEntry)}
+
+// Bounds the number of clusters collected at the same time, nil when unlimited
+var (
+	collectionSlotsMu sync.Mutex
+	collectionSlots   chan struct{}
+)
+
+var lastCollectionDesc = prometheus.NewDesc(
+	"nutanix_last_collection_timestamp_seconds",
+	"Unix time of the last successful collection of the cluster.",
+	[]string{"cluster_name", "cluster_uuid"}, nil,
+)
+
+// cacheEntry holds the last gathered metrics of one cluster
+type cacheEntry struct {
+	mu          sync.Mutex
+	gathered    *clusterGather
+	collectedAt time.Time        // Time of the last successful collection
+	inflight    *cacheCollection // Collection in progress, shared by concurrent scrapes and probes
+	running     sync.Mutex       // Serializes collections of the cluster, so a collector never runs concurrently
+}
+
+// cacheCollection is one collection of a cluster, its result is set before done is closed
+type cacheCollection struct {
+	done        chan struct{}
+	gathered    *clusterGather
+	collectedAt time.Time
+	err         error
+	abandoned   bool // The collecting request went away before it got a collection slot
+}
+
+// clusterGather is the result of one collection of a cluster
+// The metrics of every collector are also kept apart, so probes can select collectors from a shared collection
+type clusterGather struct {
+	families    []*dto.MetricFamily            // All metrics of the cluster
+	byCollector map[string][]*dto.MetricFamily // Metrics of each collector, by collector name
+	status      []*dto.MetricFamily            // The exporter's own metrics for the cluster
+	reached     bool                           // Whether any API call of the collection succeeded
+}
+
+// cacheEntryFor returns the cache entry of a cluster, creating it if needed
+// Entries are keyed by cluster ID so they survive cluster list refreshes
+func cacheEntryFor(cluster *nutanix.Cluster) *cacheEntry {
+	metricsCache.mu.Lock()
+	defer metricsCache.mu.Unlock()
+
+	entry, ok := metricsCache.entries[cluster.ID()]
+	if !ok {
+		entry = &cacheEntry{}
+		metricsCache.entries[cluster.ID()] = entry
+	}
+	return entry
+}
+
+// gatherCluster returns the metric families of a cluster and the time they were collected
+func gatherCluster(ctx context.Context, cluster *nutanix.Cluster) ([]*dto.MetricFamily, time.Time, error) {
+	gathered, collectedAt, err := gatherShared(ctx, cluster)
+	if gathered == nil {
+		return nil, collectedAt, err
+	}
+	return gathered.families, collectedAt, err
+}
+
+// gatherShared returns the latest collection of a cluster and the time it was collected
+// With background collection, the latest collection is returned and the Nutanix APIs are only called if
+// the cluster was not collected yet. Otherwise metrics younger than CacheTTL are returned without calling them.
+// Clusters in a blackout window or in quarantine return only their status metrics.
+// Waiting for a collection slot ends with an error when ctx is done.
+func gatherShared(ctx context.Context, cluster *nutanix.Cluster) (*clusterGather, time.Time, error) {
+	if blackoutActive(cluster) || !scrapeAllowed(cluster) {
+		status, err := statusRegistry(cluster).Gather()
+		return &clusterGather{families: status, status: status}, time.Now(), err
+	}
+
+	entry := cacheEntryFor(cluster)
+	if gathered, collectedAt, ok := entry.cached(); ok {
+		return gathered, collectedAt, nil
+	}
+	return entry.collect(ctx, cluster)
+}
+
+// gatherSelected returns a collection of the cluster with at least the named collectors, all if no names are given
+// A cached collection or one in progress is shared. Otherwise only the named collectors are collected,
+// and as the result is partial it is not cached.
+func gatherSelected(ctx context.Context, cluster *nutanix.Cluster, names []string) (*clusterGather, error) {
+	if len(names) == 0 {
+		gathered, _, err := gatherShared(ctx, cluster)
+		return gathered, err
+	}
+	if blackoutActive(cluster) || !scrapeAllowed(cluster) {
+		status, err := statusRegistry(cluster).Gather()
+		return &clusterGather{families: status, status: status}, err
+	}
+
+	entry := cacheEntryFor(cluster)
+	if gathered, _, ok := entry.cached(); ok {
+		return gathered, nil
+	}
+	entry.mu.Lock()
+	c := entry.inflight
+	entry.mu.Unlock()
+	if c != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !c.abandoned {
+			return c.gathered, c.err
+		}
+	}
+
+	release, err := acquireCollectionSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	entry.running.Lock()
+	defer entry.running.Unlock()
+	return collectCluster(cluster, names)
+}
+
+// selected returns the status metrics and the metrics of the named collectors, or all metrics if no names are given
+// Collectors without metrics in the collection are skipped
+func (g *clusterGather) selected(names []string) ([]*dto.MetricFamily, error) {
+	if len(names) == 0 {
+		return g.families, nil
+	}
+	gatherers := prometheus.Gatherers{staticGatherer(g.status)}
+	for _, name := range names {
+		if families, ok := g.byCollector[name]; ok {
+			gatherers = append(gatherers, staticGatherer(families))
+		}
+	}
+	return gatherers.Gather()
+}
+
+// staticGatherer returns a gatherer of already gathered metric families
+func staticGatherer(families []*dto.MetricFamily) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })
+}
+
+// gatherCollectors gathers the named collectors of the cluster, or all if no names are given, each through
+// its own registry and concurrently as a single registry would, followed by the status metrics.
+// The results are merged into one set of families.
+func gatherCollectors(cluster *nutanix.Cluster, names []string) (*clusterGather, error) {
+	gathered := &clusterGather{byCollector: make(map[string][]*dto.MetricFamily, len(cluster.Collectors))}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs prometheus.MultiError
+	)
+	for _, collector := range cluster.Collectors {
+		name := fmt.Sprintf("%T", collector)
+		if rc, ok := collector.(*prom.RecoveringCollector); ok {
+			name = rc.Name
+		}
+		if len(names) > 0 && !slices.Contains(names, name) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name string, collector prometheus.Collector) {
+			defer wg.Done()
+			registry := prometheus.NewRegistry()
+			err := registry.Register(collector)
+			var families []*dto.MetricFamily
+			if err == nil {
+				families, err = registry.Gather()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			gathered.byCollector[name] = families
+			errs.Append(err)
+		}(name, collector)
+	}
+	wg.Wait()
+
+	// The status metrics are gathered last, so they include the outcome of this collection
+	status, err := statusRegistry(cluster).Gather()
+	errs.Append(err)
+	gathered.status = status
+
+	gatherers := prometheus.Gatherers{staticGatherer(status)}
+	for _, families := range gathered.byCollector {
+		gatherers = append(gatherers, staticGatherer(families))
+	}
+	families, err := gatherers.Gather()
+	errs.Append(err)
+	gathered.families = families
+
+	return gathered, errs.MaybeUnwrap()
+}
+
+// scrapeAllowed reports whether a scrape may serve the metrics of the cluster
+// With background collection, quarantine probes are left to the background collector
+func scrapeAllowed(cluster *nutanix.Cluster) bool {
+	if CollectionInterval > 0 {
+		return !quarantined(cluster)
+	}
+	return quarantineAllows(cluster)
+}
+
+// cached returns the last successful collection if it may still be served
+func (e *cacheEntry) cached() (*clusterGather, time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.gathered == nil {
+		return nil, time.Time{}, false
+	}
+	if ttl := current().CacheTTL; CollectionInterval > 0 || (ttl > 0 && time.Since(e.collectedAt) < ttl) {
+		return e.gathered, e.collectedAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// collect collects the cluster, or waits for the collection already in progress and shares its result
+// If the request collecting the cluster goes away while waiting for a collection slot, a waiting
+// request takes over the collection
+func (e *cacheEntry) collect(ctx context.Context, cluster *nutanix.Cluster) (*clusterGather, time.Time, error) {
+	e.mu.Lock()
+	for e.inflight != nil {
+		c := e.inflight
+		e.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, time.Time{}, ctx.Err()
+		}
+		if !c.abandoned {
+			return c.gathered, c.collectedAt, c.err
+		}
+		e.mu.Lock()
+	}
+	c := &cacheCollection{done: make(chan struct{})}
+	e.inflight = c
+	e.mu.Unlock()
+
+	release, err := acquireCollectionSlot(ctx)
+	if err != nil {
+		e.mu.Lock()
+		c.err = err
+		c.abandoned = true
+		e.inflight = nil
+		e.mu.Unlock()
+		close(c.done)
+		return nil, time.Time{}, err
+	}
+	e.running.Lock()
+	c.gathered, c.err = collectCluster(cluster, nil)
+	c.collectedAt = time.Now()
+	e.running.Unlock()
+	release()
+
+	// A collection that reached no API of the cluster keeps the previous metrics and collection time
+	e.mu.Lock()
+	if c.err == nil && c.gathered.reached {
+		e.gathered = c.gathered
+		e.collectedAt = c.collectedAt
+	}
+	e.inflight = nil
+	e.mu.Unlock()
+	close(c.done)
+
+	return c.gathered, c.collectedAt, c.err
+}
+
+// lastCollection returns the time of the last successful collection of the cluster, zero if there was none
+func lastCollection(cluster *nutanix.Cluster) time.Time {
+	entry := cacheEntryFor(cluster)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.collectedAt
+}
+
+// setMaxConcurrentCollections bounds the number of clusters collected at the same time, 0 is unlimited
+// Collections in progress keep the slot they acquired
+func setMaxConcurrentCollections(n int) {
+	collectionSlotsMu.Lock()
+	defer collectionSlotsMu.Unlock()
+
+	if n <= 0 {
+		collectionSlots = nil
+	} else if cap(collectionSlots) != n {
+		collectionSlots = make(chan struct{}, n)
+	}
+}
+
+// acquireCollectionSlot waits until another cluster may be collected, or until ctx is done
+// The returned function releases the slot
+func acquireCollectionSlot(ctx context.Context) (func(), error) {
+	collectionSlotsMu.Lock()
+	slots := collectionSlots
+	collectionSlotsMu.Unlock()
+
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runCollection collects every cluster in the background every CollectionInterval
+// Scrapes serve the result of the latest collection
+func runCollection(ctx context.Context) {
+	ticker := time.NewTicker(CollectionInterval)
+	defer ticker.Stop()
+
+	for {
+		done := trackIteration("background collection")
+		clustersMu.RLock()
+		clusters := make([]*nutanix.Cluster, 0, len(ClustersMap))
+		for _, cluster := range ClustersMap {
+			clusters = append(clusters, cluster)
+		}
+		clustersMu.RUnlock()
+
+		var wg sync.WaitGroup
+		for _, cluster := range clusters {
+			if blackoutActive(cluster) || !quarantineAllows(cluster) {
+				continue
+			}
+			wg.Add(1)
+			go func(cluster *nutanix.Cluster) {
+				defer wg.Done()
+				cluster.RefreshCredentialsIfNeeded()
+				if _, _, err := cacheEntryFor(cluster).collect(ctx, cluster); err != nil {
+					log.Printf("Background collection of cluster %s failed: %v", cluster.Name, err)
+				}
+			}(cluster)
+		}
+		wg.Wait()
+		done()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lastCollectionCollector exposes the time of the last successful collection of a cluster,
+// so stale metrics served from the cache can be detected
+type lastCollectionCollector struct {
+	cluster *nutanix.Cluster
+}
+
+// Describe method required by prometheus.Collector interface
+func (c *lastCollectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastCollectionDesc
+}
+
+// Collect method required by prometheus.Collector interface
+func (c *lastCollectionCollector) Collect(ch chan<- prometheus.Metric) {
+	collectedAt := lastCollection(c.cluster)
+	if collectedAt.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(lastCollectionDesc, prometheus.GaugeValue,
+		float64(collectedAt.UnixNano())/1e9, c.cluster.Name, c.cluster.UUID)
+}
+
+// cacheMaxAge returns how long metrics collected at collectedAt remain current, false if metrics are not cached
+func cacheMaxAge(collectedAt time.Time) (time.Duration, bool) {
+	ttl := current().CacheTTL
+	switch {
+	case CollectionInterval > 0:
+		return CollectionInterval - time.Since(collectedAt), true
+	case ttl > 0:
+		return ttl - time.Since(collectedAt), true
+	}
+	return 0, false
+}
+
+// checkNotModified sets the Last-Modified and Cache-Control headers and answers conditional requests
+// A maxAge of 0 asks clients to revalidate on every request
+// Returns true if a 304 Not Modified response was written
+func checkNotModified(w http.ResponseWriter, r *http.Request, lastModified time.Time, maxAge time.Duration) bool {
+	// HTTP dates have second precision
+	lastModified = lastModified.UTC().Truncate(time.Second)
+
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testCollector exports one gauge named after it, marking the cluster reachable unless it is set to fail
+type testCollector struct {
+	cluster *nutanix.Cluster
+	desc    *prometheus.Desc
+	fail    atomic.Bool
+	calls   atomic.Int32
+}
+
+func (c *testCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *testCollector) Collect(ch chan<- prometheus.Metric) {
+	c.calls.Add(1)
+	if c.fail.Load() {
+		return
+	}
+	c.cluster.MarkReachable()
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+}
+
+// newTestCluster returns a cluster with a test collector of each given name
+func newTestCluster(t *testing.T, name string, collectors ...string) (*nutanix.Cluster, map[string]*testCollector) {
+	t.Helper()
+	cluster := &nutanix.Cluster{Name: name, UUID: name + "-uuid"}
+	byName := make(map[string]*testCollector, len(collectors))
+	for _, collectorName := range collectors {
+		c := &testCollector{
+			cluster: cluster,
+			desc:    prometheus.NewDesc("test_"+collectorName, "Test metric.", nil, nil),
+		}
+		byName[collectorName] = c
+		cluster.Collectors = append(cluster.Collectors, prom.WithRecovery(cluster, collectorName, c))
+	}
+	t.Cleanup(func() {
+		metricsCache.mu.Lock()
+		delete(metricsCache.entries, cluster.ID())
+		metricsCache.mu.Unlock()
+	})
+	return cluster, byName
+}
+
+// familyNames returns the names of the metric families
+func familyNames(families []*dto.MetricFamily) map[string]bool {
+	names := make(map[string]bool, len(families))
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	return names
+}
+
+func TestCacheEntryCollectKeepsPreviousOnFailure(t *testing.T) {
+	cluster, collectors := newTestCluster(t, "cache-failure", "host")
+	entry := cacheEntryFor(cluster)
+
+	gathered, collectedAt, err := entry.collect(context.Background(), cluster)
+	if err != nil || !gathered.reached {
+		t.Fatalf("first collection: reached = %v, error = %v", gathered.reached, err)
+	}
+	if !familyNames(gathered.families)["test_host"] {
+		t.Fatalf("first collection is missing test_host")
+	}
+
+	collectors["host"].fail.Store(true)
+	failed, _, err := entry.collect(context.Background(), cluster)
+	if err != nil || failed.reached {
+		t.Fatalf("failed collection: reached = %v, error = %v", failed.reached, err)
+	}
+
+	entry.mu.Lock()
+	cached, cachedAt := entry.gathered, entry.collectedAt
+	entry.mu.Unlock()
+	if cached != gathered || !cachedAt.Equal(collectedAt) {
+		t.Errorf("failed collection replaced the previous one, collected at %s, want %s", cachedAt, collectedAt)
+	}
+	if last := lastCollection(cluster); !last.Equal(collectedAt) {
+		t.Errorf("last collection = %s, want %s", last, collectedAt)
+	}
+
+	collectors["host"].fail.Store(false)
+	recovered, recoveredAt, err := entry.collect(context.Background(), cluster)
+	if err != nil || !recovered.reached {
+		t.Fatalf("recovered collection: reached = %v, error = %v", recovered.reached, err)
+	}
+	if last := lastCollection(cluster); !last.Equal(recoveredAt) {
+		t.Errorf("last collection = %s, want %s", last, recoveredAt)
+	}
+}
+
+func TestCacheEntryCollectStopsWaitingForSlot(t *testing.T) {
+	setMaxConcurrentCollections(1)
+	t.Cleanup(func() { setMaxConcurrentCollections(0) })
+	cluster, collectors := newTestCluster(t, "cache-slot", "host")
+	entry := cacheEntryFor(cluster)
+
+	release, err := acquireCollectionSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request goes away while waiting for the slot, the second takes over its collection
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := entry.collect(ctx, cluster)
+		first <- err
+	}()
+	waitFor(t, func() bool {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		return entry.inflight != nil
+	})
+	second := make(chan error, 1)
+	go func() {
+		_, _, err := entry.collect(context.Background(), cluster)
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first collection error = %v, want %v", err, context.Canceled)
+	}
+	if calls := collectors["host"].calls.Load(); calls != 0 {
+		t.Errorf("collector called %d times without a slot", calls)
+	}
+
+	release()
+	if err := <-second; err != nil {
+		t.Errorf("second collection error = %v", err)
+	}
+	if calls := collectors["host"].calls.Load(); calls != 1 {
+		t.Errorf("collector called %d times, want 1", calls)
+	}
+}
+
+// waitFor polls the condition until it holds, failing the test after a second
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	lastModified := time.Date(2024, 6, 1, 12, 0, 0, 500_000_000, time.UTC)
+	tests := []struct {
+		name             string
+		ifModifiedSince  string
+		maxAge           time.Duration
+		wantNotModified  bool
+		wantCacheControl string
+	}{
+		{"unconditional request", "", 30 * time.Second, false, "max-age=30"},
+		{"not modified since", "Sat, 01 Jun 2024 12:00:00 GMT", 30 * time.Second, true, "max-age=30"},
+		{"not modified since a later time", "Sat, 01 Jun 2024 12:05:00 GMT", 30 * time.Second, true, "max-age=30"},
+		{"modified since", "Sat, 01 Jun 2024 11:59:59 GMT", 30 * time.Second, false, "max-age=30"},
+		{"invalid date", "yesterday", 30 * time.Second, false, "max-age=30"},
+		{"expired", "Sat, 01 Jun 2024 12:00:00 GMT", 0, true, "no-cache"},
+		{"overdue", "", -time.Second, false, "no-cache"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics/cluster-a", nil)
+			if tt.ifModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			rec := httptest.NewRecorder()
+
+			if got := checkNotModified(rec, req, lastModified, tt.maxAge); got != tt.wantNotModified {
+				t.Errorf("checkNotModified = %v, want %v", got, tt.wantNotModified)
+			}
+			if tt.wantNotModified && rec.Code != http.StatusNotModified {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNotModified)
+			}
+			// HTTP dates have second precision
+			if got := rec.Header().Get("Last-Modified"); got != "Sat, 01 Jun 2024 12:00:00 GMT" {
+				t.Errorf("Last-Modified = %q", got)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCacheControl)
+			}
+		})
+	}
+}
+
+func TestClusterMetricsConditionalRequest(t *testing.T) {
+	config := DefaultConfig()
+	config.MetricsCacheTTL = time.Hour
+	setTestSettings(t, config)
+	cluster, collectors := newTestCluster(t, "cache-conditional", "host")
+	handler := createClusterMetricsHandler(cluster)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/metrics/cache-conditional", nil))
+	lastModified := rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || lastModified == "" {
+		t.Fatalf("first scrape: status = %d, Last-Modified = %q", rec.Code, lastModified)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics/cache-conditional", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional scrape: status = %d with %d bytes, want %d without a body", rec.Code, rec.Body.Len(), http.StatusNotModified)
+	}
+	if calls := collectors["host"].calls.Load(); calls != 1 {
+		t.Errorf("collector called %d times, want once for the cached collection", calls)
+	}
+}
@@ -0,0 +1,409 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultClusterTimeout    = 10 * time.Second
+	DefaultWatchdogInterval  = 10 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultServerIdleTimeout = 2 * time.Minute
+)
+
+// Config holds the complete exporter configuration
+// It is built from the defaults, overridden by environment variables, overridden by the config file
+type Config struct {
+	ListenAddress     string        `yaml:"listen_address"`
+	WebConfigFile     string        `yaml:"web_config_file"` // TLS and HTTP server settings in the exporter-toolkit format
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+
+	AdminUsername     string        `yaml:"admin_username"`
+	AdminPassword     string        `yaml:"admin_password"`
+	DrainDelay        time.Duration `yaml:"drain_delay"`
+	DrainTimeout      time.Duration `yaml:"drain_timeout"`
+	ReadinessWindow   time.Duration `yaml:"readiness_window"`
+	LivenessThreshold time.Duration `yaml:"liveness_threshold"`
+
+	FederationOnly   bool   `yaml:"federation_only"`
+	FederationConfig string `yaml:"federation_config"`
+	TenantsConfig    string `yaml:"tenants_config"`
+	GroupsConfig     string `yaml:"groups_config"`
+	BlackoutConfig   string `yaml:"blackout_config"`
+
+	PrismCentral *PrismCentralConfig `yaml:"prism_central"` // Optional if static clusters are configured
+	Clusters     []ClusterTarget     `yaml:"clusters"`      // Static clusters, served in addition to discovered ones
+	Credentials  CredentialsConfig   `yaml:"credentials"`   // Default credential provider of all clusters
+	Collectors   []string            `yaml:"collectors"`    // Collectors of clusters without their own selection
+
+	VaultRefreshInterval     time.Duration `yaml:"vault_refresh_interval"`
+	MetricsCacheTTL          time.Duration `yaml:"metrics_cache_ttl"`
+	CollectionInterval       time.Duration `yaml:"collection_interval"`
+	MaxConcurrentCollections int           `yaml:"max_concurrent_collections"`
+	MaxClusterRequests       int           `yaml:"max_cluster_requests"`
+	DNSServers               []string      `yaml:"dns_servers"`
+	DNSTimeout               time.Duration `yaml:"dns_timeout"`
+	VMDiskMetrics            bool          `yaml:"vm_disk_metrics"`
+	QuarantineThreshold      int           `yaml:"quarantine_threshold"`
+	QuarantineProbeInterval  time.Duration `yaml:"quarantine_probe_interval"`
+	ReloginInterval          time.Duration `yaml:"relogin_interval"`
+	WatchdogInterval         time.Duration `yaml:"watchdog_interval"`
+	WatchdogGrace            time.Duration `yaml:"watchdog_grace"`
+	WatchdogRecycleClient    bool          `yaml:"watchdog_recycle_client"`
+}
+
+// PrismCentralConfig holds the Prism Central connection used for cluster discovery
+type PrismCentralConfig struct {
+	Name            string        `yaml:"name"`
+	URL             string        `yaml:"url"`
+	APIVersion      string        `yaml:"api_version"`      // v3, v4b1 or v4, defaults to v4
+	ClusterPrefix   string        `yaml:"cluster_prefix"`   // Only clusters with this name prefix are discovered
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // 0 disables refreshing
+	SeedFile        string        `yaml:"seed_file"`        // Cluster list used when Prism Central is unreachable at startup
+
+	Credentials *CredentialsConfig `yaml:"credentials"` // Optional, overrides the default credential provider
+}
+
+// CredentialsConfig selects where the credentials of a cluster are read from
+type CredentialsConfig struct {
+	Provider string `yaml:"provider"` // vault, file or env, defaults to vault
+	Path     string `yaml:"path"`     // Directory of the file provider
+	Prefix   string `yaml:"prefix"`   // Environment variable prefix of the env provider, defaults to NUTANIX
+}
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddress:           ListenAddress,
+		ReadHeaderTimeout:       DefaultReadHeaderTimeout,
+		IdleTimeout:             DefaultServerIdleTimeout,
+		DrainDelay:              DefaultDrainDelay,
+		DrainTimeout:            DefaultDrainTimeout,
+		ReadinessWindow:         DefaultReadinessWindow,
+		LivenessThreshold:       DefaultLivenessThreshold,
+		Credentials:             CredentialsConfig{Provider: "vault"},
+		Collectors:              DefaultCollectors,
+		DNSTimeout:              nutanix.DefaultDNSTimeout,
+		QuarantineProbeInterval: DefaultQuarantineProbeInterval,
+		WatchdogInterval:        DefaultWatchdogInterval,
+		WatchdogGrace:           prom.DefaultWatchdogGrace,
+	}
+}
+
+// LoadConfig builds the configuration from the defaults, the environment and the optional config file
+func LoadConfig(configPath string) (*Config, error) {
+	config := DefaultConfig()
+	config.applyEnv()
+
+	if configPath != "" {
+		yamlFile, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(yamlFile, config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", configPath, err)
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// applyEnv overrides the configuration with the environment variables that are set
+// Durations are given in seconds and only positive values are applied
+func (c *Config) applyEnv() {
+	envString("WEB_CONFIG_FILE", &c.WebConfigFile)
+	envSeconds("SERVER_READ_HEADER_TIMEOUT", &c.ReadHeaderTimeout)
+	envSeconds("SERVER_IDLE_TIMEOUT", &c.IdleTimeout)
+
+	envString("ADMIN_USERNAME", &c.AdminUsername)
+	envString("ADMIN_PASSWORD", &c.AdminPassword)
+	envSeconds("DRAIN_DELAY", &c.DrainDelay)
+	envSeconds("DRAIN_TIMEOUT", &c.DrainTimeout)
+	envSeconds("READINESS_WINDOW", &c.ReadinessWindow)
+	envSeconds("LIVENESS_THRESHOLD", &c.LivenessThreshold)
+
+	envBool("FEDERATION_ONLY", &c.FederationOnly)
+	envString("FEDERATION_CONFIG", &c.FederationConfig)
+	envString("TENANTS_CONFIG", &c.TenantsConfig)
+	envString("GROUPS_CONFIG", &c.GroupsConfig)
+	envString("BLACKOUT_CONFIG", &c.BlackoutConfig)
+
+	if os.Getenv("PC_CLUSTER_NAME") != "" || os.Getenv("PC_CLUSTER_URL") != "" {
+		c.PrismCentral = &PrismCentralConfig{}
+		envString("PC_CLUSTER_NAME", &c.PrismCentral.Name)
+		envString("PC_CLUSTER_URL", &c.PrismCentral.URL)
+		envString("PC_API_VERSION", &c.PrismCentral.APIVersion)
+		envString("CLUSTER_PREFIX", &c.PrismCentral.ClusterPrefix)
+		envSeconds("CLUSTER_REFRESH_INTERVAL", &c.PrismCentral.RefreshInterval)
+		envString("CLUSTER_SEED_FILE", &c.PrismCentral.SeedFile)
+	}
+
+	if collectors := os.Getenv("COLLECTORS"); collectors != "" {
+		c.Collectors = strings.Split(collectors, ",")
+	}
+	envString("CREDENTIALS_PROVIDER", &c.Credentials.Provider)
+	envString("CREDENTIALS_PATH", &c.Credentials.Path)
+	envString("CREDENTIALS_ENV_PREFIX", &c.Credentials.Prefix)
+	envSeconds("VAULT_REFRESH_INTERVAL", &c.VaultRefreshInterval)
+	envSeconds("METRICS_CACHE_TTL", &c.MetricsCacheTTL)
+	envSeconds("COLLECTION_INTERVAL", &c.CollectionInterval)
+	envInt("MAX_CONCURRENT_COLLECTIONS", &c.MaxConcurrentCollections)
+	envInt("MAX_CLUSTER_REQUESTS", &c.MaxClusterRequests)
+	if dnsServers := os.Getenv("DNS_SERVERS"); dnsServers != "" {
+		c.DNSServers = strings.Split(dnsServers, ",")
+	}
+	envSeconds("DNS_TIMEOUT", &c.DNSTimeout)
+	envBool("VM_DISK_METRICS", &c.VMDiskMetrics)
+	envInt("QUARANTINE_THRESHOLD", &c.QuarantineThreshold)
+	envSeconds("QUARANTINE_PROBE_INTERVAL", &c.QuarantineProbeInterval)
+	envSeconds("CLUSTER_RELOGIN_INTERVAL", &c.ReloginInterval)
+	envSeconds("WATCHDOG_INTERVAL", &c.WatchdogInterval)
+	envSeconds("WATCHDOG_GRACE", &c.WatchdogGrace)
+	envBool("WATCHDOG_RECYCLE_CLIENT", &c.WatchdogRecycleClient)
+}
+
+// validate checks the configuration and fills in the defaults that depend on other settings
+func (c *Config) validate() error {
+	if err := c.validateRanges(); err != nil {
+		return err
+	}
+
+	if c.FederationOnly {
+		if c.FederationConfig == "" {
+			return fmt.Errorf("federation-only mode requires a federation config")
+		}
+		return nil
+	}
+
+	if c.PrismCentral == nil && len(c.Clusters) == 0 {
+		return fmt.Errorf("neither Prism Central nor static clusters are configured")
+	}
+
+	if err := c.Credentials.validate(); err != nil {
+		return err
+	}
+
+	if len(c.Collectors) == 0 {
+		return fmt.Errorf("no collectors are enabled")
+	}
+	if err := validateCollectors(c.Collectors); err != nil {
+		return err
+	}
+
+	if pc := c.PrismCentral; pc != nil {
+		if pc.Name == "" || pc.URL == "" {
+			return fmt.Errorf("prism central requires a name and a url")
+		}
+		switch pc.APIVersion {
+		case "":
+			pc.APIVersion = "v4"
+		case "v3", "v4b1", "v4":
+		default:
+			return fmt.Errorf("unsupported prism central api version %s", pc.APIVersion)
+		}
+		if pc.Credentials != nil {
+			if err := pc.Credentials.validate(); err != nil {
+				return fmt.Errorf("prism central: %v", err)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i]
+		if cluster.Name == "" || cluster.URL == "" {
+			return fmt.Errorf("static cluster without a name or url")
+		}
+		if cluster.UUID == "" {
+			return fmt.Errorf("static cluster %s without a uuid", cluster.Name)
+		}
+		if seen[cluster.Name] {
+			return fmt.Errorf("duplicate static cluster %s", cluster.Name)
+		}
+		if seen[cluster.UUID] {
+			return fmt.Errorf("duplicate uuid %s of static cluster %s", cluster.UUID, cluster.Name)
+		}
+		seen[cluster.Name] = true
+		seen[cluster.UUID] = true
+		if err := validateCollectors(cluster.Collectors); err != nil {
+			return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		if cluster.Timeout < 0 {
+			return fmt.Errorf("negative timeout for cluster %s", cluster.Name)
+		}
+		credentials := c.Credentials
+		if cluster.Credentials != nil {
+			if err := cluster.Credentials.validate(); err != nil {
+				return fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+			credentials = *cluster.Credentials
+		}
+		if cluster.VaultPath != "" && credentials.Provider != "vault" {
+			return fmt.Errorf("cluster %s has a vault_path but uses the %s credential provider", cluster.Name, credentials.Provider)
+		}
+	}
+
+	return nil
+}
+
+// durationSetting is a duration of the configuration, by its name in the config file
+type durationSetting struct {
+	name  string
+	value time.Duration
+}
+
+// validateRanges checks the durations and limits of the config file, the environment only applies positive values
+// Intervals and timeouts that are always used must be positive, settings where 0 disables a feature must not be negative
+func (c *Config) validateRanges() error {
+	positive := []durationSetting{
+		{"drain_timeout", c.DrainTimeout},
+		{"readiness_window", c.ReadinessWindow},
+		{"liveness_threshold", c.LivenessThreshold},
+		{"dns_timeout", c.DNSTimeout},
+		{"quarantine_probe_interval", c.QuarantineProbeInterval},
+		{"watchdog_interval", c.WatchdogInterval},
+	}
+	for _, d := range positive {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", d.name, d.value)
+		}
+	}
+
+	nonNegative := []durationSetting{
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"drain_delay", c.DrainDelay},
+		{"vault_refresh_interval", c.VaultRefreshInterval},
+		{"metrics_cache_ttl", c.MetricsCacheTTL},
+		{"collection_interval", c.CollectionInterval},
+		{"relogin_interval", c.ReloginInterval},
+		{"watchdog_grace", c.WatchdogGrace},
+	}
+	if c.PrismCentral != nil {
+		nonNegative = append(nonNegative, durationSetting{"prism_central.refresh_interval", c.PrismCentral.RefreshInterval})
+	}
+	for _, d := range nonNegative {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"max_concurrent_collections", c.MaxConcurrentCollections},
+		{"max_cluster_requests", c.MaxClusterRequests},
+		{"quarantine_threshold", c.QuarantineThreshold},
+	}
+	for _, l := range limits {
+		if l.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", l.name, l.value)
+		}
+	}
+	return nil
+}
+
+// validateCollectors checks that every collector is known and selected only once
+func validateCollectors(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := collectorFactories[name]; !ok {
+			return fmt.Errorf("unknown collector %s", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate collector %s", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// usesVault reports whether any cluster reads its credentials from Vault
+// Discovered clusters use the default provider
+func (c *Config) usesVault() bool {
+	if c.Credentials.Provider == "vault" {
+		return true
+	}
+	if c.PrismCentral != nil && c.PrismCentral.Credentials != nil && c.PrismCentral.Credentials.Provider == "vault" {
+		return true
+	}
+	for _, cluster := range c.Clusters {
+		if cluster.Credentials != nil && cluster.Credentials.Provider == "vault" {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the credential provider settings, defaulting to Vault
+func (c *CredentialsConfig) validate() error {
+	switch c.Provider {
+	case "":
+		c.Provider = "vault"
+	case "vault", "env":
+	case "file":
+		if c.Path == "" {
+			return fmt.Errorf("the file credential provider requires a path")
+		}
+	default:
+		return fmt.Errorf("unknown credential provider %s", c.Provider)
+	}
+	return nil
+}
+
+// envString sets dst to the environment variable if it is set
+func envString(name string, dst *string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+// envBool sets dst to true if the environment variable is "true"
+func envBool(name string, dst *bool) {
+	if os.Getenv(name) == "true" {
+		*dst = true
+	}
+}
+
+// envInt sets dst to the environment variable if it is a positive number
+func envInt(name string, dst *int) {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		*dst = v
+	}
+}
+
+// envSeconds sets dst to the environment variable if it is a positive number of seconds
+func envSeconds(name string, dst *time.Duration) {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		*dst = time.Duration(v) * time.Second
+	}
+}
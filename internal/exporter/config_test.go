@@ -0,0 +1,259 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv are the environment variables read by LoadConfig
+var configEnv = []string{
+	"ADMIN_PASSWORD", "ADMIN_USERNAME", "BLACKOUT_CONFIG", "CLUSTER_PREFIX", "CLUSTER_REFRESH_INTERVAL",
+	"CLUSTER_RELOGIN_INTERVAL", "CLUSTER_SEED_FILE", "COLLECTION_INTERVAL", "COLLECTORS", "CREDENTIALS_ENV_PREFIX",
+	"CREDENTIALS_PATH", "CREDENTIALS_PROVIDER", "DNS_SERVERS", "DNS_TIMEOUT", "DRAIN_DELAY", "DRAIN_TIMEOUT",
+	"FEDERATION_CONFIG", "FEDERATION_ONLY", "GROUPS_CONFIG", "LIVENESS_THRESHOLD", "MAX_CLUSTER_REQUESTS",
+	"MAX_CONCURRENT_COLLECTIONS", "METRICS_CACHE_TTL", "PC_API_VERSION", "PC_CLUSTER_NAME", "PC_CLUSTER_URL",
+	"QUARANTINE_PROBE_INTERVAL", "QUARANTINE_THRESHOLD", "READINESS_WINDOW", "SERVER_IDLE_TIMEOUT",
+	"SERVER_READ_HEADER_TIMEOUT", "TENANTS_CONFIG", "VAULT_REFRESH_INTERVAL", "VM_DISK_METRICS", "WATCHDOG_GRACE",
+	"WATCHDOG_INTERVAL", "WATCHDOG_RECYCLE_CLIENT", "WEB_CONFIG_FILE",
+}
+
+// setConfigEnv clears the configuration environment of the test and sets the given variables
+func setConfigEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range configEnv {
+		t.Setenv(name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+// writeConfig writes a config file for the test and returns its path, or "" for no file
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	if content == "" {
+		return ""
+	}
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	pcEnv := map[string]string{"PC_CLUSTER_NAME": "pc", "PC_CLUSTER_URL": "https://pc:9440"}
+	withPC := func(env map[string]string) map[string]string {
+		merged := map[string]string{}
+		for k, v := range pcEnv {
+			merged[k] = v
+		}
+		for k, v := range env {
+			merged[k] = v
+		}
+		return merged
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		file  string
+		check func(t *testing.T, c *Config)
+	}{
+		{
+			name: "defaults",
+			env:  pcEnv,
+			check: func(t *testing.T, c *Config) {
+				if c.ListenAddress != ListenAddress || c.DrainDelay != DefaultDrainDelay || c.DrainTimeout != DefaultDrainTimeout || c.MetricsCacheTTL != 0 {
+					t.Errorf("unexpected defaults %+v", c)
+				}
+				if !reflect.DeepEqual(c.Collectors, DefaultCollectors) {
+					t.Errorf("collectors = %v, want %v", c.Collectors, DefaultCollectors)
+				}
+				if c.Credentials.Provider != "vault" {
+					t.Errorf("credential provider = %q, want vault", c.Credentials.Provider)
+				}
+				if c.PrismCentral == nil || c.PrismCentral.APIVersion != "v4" {
+					t.Errorf("prism central = %+v, want api version v4", c.PrismCentral)
+				}
+			},
+		},
+		{
+			name: "environment overrides defaults, in seconds",
+			env:  withPC(map[string]string{"METRICS_CACHE_TTL": "60", "QUARANTINE_THRESHOLD": "3", "COLLECTORS": "cluster,host"}),
+			check: func(t *testing.T, c *Config) {
+				if c.MetricsCacheTTL != time.Minute || c.QuarantineThreshold != 3 {
+					t.Errorf("cache ttl = %s, quarantine threshold = %d", c.MetricsCacheTTL, c.QuarantineThreshold)
+				}
+				if !reflect.DeepEqual(c.Collectors, []string{"cluster", "host"}) {
+					t.Errorf("collectors = %v", c.Collectors)
+				}
+			},
+		},
+		{
+			name: "non-positive and invalid environment values are ignored",
+			env:  withPC(map[string]string{"DRAIN_TIMEOUT": "-5", "QUARANTINE_THRESHOLD": "0", "METRICS_CACHE_TTL": "1m"}),
+			check: func(t *testing.T, c *Config) {
+				if c.DrainTimeout != DefaultDrainTimeout || c.QuarantineThreshold != 0 || c.MetricsCacheTTL != 0 {
+					t.Errorf("drain timeout = %s, quarantine threshold = %d, cache ttl = %s",
+						c.DrainTimeout, c.QuarantineThreshold, c.MetricsCacheTTL)
+				}
+			},
+		},
+		{
+			name: "file overrides environment, durations with units",
+			env:  withPC(map[string]string{"METRICS_CACHE_TTL": "60", "COLLECTORS": "cluster,host", "ADMIN_USERNAME": "env"}),
+			file: "metrics_cache_ttl: 5m\ncollectors: [vm]\n",
+			check: func(t *testing.T, c *Config) {
+				if c.MetricsCacheTTL != 5*time.Minute {
+					t.Errorf("cache ttl = %s, want 5m", c.MetricsCacheTTL)
+				}
+				if !reflect.DeepEqual(c.Collectors, []string{"vm"}) {
+					t.Errorf("collectors = %v, want [vm]", c.Collectors)
+				}
+				if c.AdminUsername != "env" {
+					t.Errorf("admin username = %q, settings missing from the file keep the environment", c.AdminUsername)
+				}
+			},
+		},
+		{
+			name: "file overrides single prism central settings of the environment",
+			env:  withPC(map[string]string{"PC_API_VERSION": "v3"}),
+			file: "prism_central:\n  name: file-pc\n  url: https://file-pc:9440\n",
+			check: func(t *testing.T, c *Config) {
+				if c.PrismCentral.Name != "file-pc" || c.PrismCentral.APIVersion != "v3" {
+					t.Errorf("prism central = %+v", c.PrismCentral)
+				}
+			},
+		},
+		{
+			name: "static clusters without prism central",
+			file: "clusters:\n  - name: pe-01\n    url: https://10.0.0.10:9440\n    uuid: pe-01-uuid\n    timeout: 30s\n  - name: pe-02\n    url: https://10.0.0.20:9440\n    uuid: pe-02-uuid\n    collectors: [cluster]\n",
+			check: func(t *testing.T, c *Config) {
+				if c.PrismCentral != nil {
+					t.Errorf("prism central = %+v, want none", c.PrismCentral)
+				}
+				if len(c.Clusters) != 2 || c.Clusters[0].Timeout != 30*time.Second || c.Clusters[1].Timeout != 0 {
+					t.Errorf("clusters = %+v", c.Clusters)
+				}
+				if !c.usesVault() {
+					t.Errorf("static clusters with the default provider use Vault")
+				}
+			},
+		},
+		{
+			name: "static clusters without vault",
+			file: "credentials:\n  provider: file\n  path: /secrets\nclusters:\n  - name: pe-01\n    url: https://10.0.0.10:9440\n    uuid: pe-01-uuid\n  - name: pe-02\n    url: https://10.0.0.20:9440\n    uuid: pe-02-uuid\n    credentials:\n      provider: env\n",
+			check: func(t *testing.T, c *Config) {
+				if c.usesVault() {
+					t.Errorf("no cluster uses the vault provider")
+				}
+			},
+		},
+		{
+			name: "static cluster with its own vault provider",
+			env:  map[string]string{"CREDENTIALS_PROVIDER": "env"},
+			file: "clusters:\n  - name: pe-01\n    url: https://10.0.0.10:9440\n    uuid: pe-01-uuid\n    vault_path: pe/01\n    credentials:\n      provider: vault\n",
+			check: func(t *testing.T, c *Config) {
+				if !c.usesVault() {
+					t.Errorf("pe-01 uses the vault provider")
+				}
+			},
+		},
+		{
+			name: "federation only",
+			env:  map[string]string{"FEDERATION_ONLY": "true", "FEDERATION_CONFIG": "/configs/regions.yaml"},
+			check: func(t *testing.T, c *Config) {
+				if !c.FederationOnly || c.PrismCentral != nil {
+					t.Errorf("config = %+v", c)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfigEnv(t, tt.env)
+			config, err := LoadConfig(writeConfig(t, tt.file))
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	const static = "clusters:\n  - name: pe-01\n    url: https://10.0.0.10:9440\n    uuid: pe-01-uuid\n"
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{"nothing to monitor", nil, "", "neither Prism Central nor static clusters"},
+		{"invalid yaml", nil, "clusters: [", "failed to parse"},
+		{"cluster without url", nil, "clusters:\n  - name: pe-01\n", "static cluster without a name or url"},
+		{"cluster without uuid", nil, "clusters:\n  - name: pe-01\n    url: https://10.0.0.10:9440\n", "static cluster pe-01 without a uuid"},
+		{"duplicate cluster", nil, static + "  - name: pe-01\n    url: https://10.0.0.20:9440\n    uuid: pe-02-uuid\n", "duplicate static cluster pe-01"},
+		{"duplicate uuid", nil, static + "  - name: pe-02\n    url: https://10.0.0.20:9440\n    uuid: pe-01-uuid\n", "duplicate uuid pe-01-uuid of static cluster pe-02"},
+		{"negative timeout", nil, static + "    timeout: -1s\n", "negative timeout"},
+		{"no collectors", nil, static + "collectors: []\n", "no collectors are enabled"},
+		{"unknown collector", nil, static + "collectors: [cluster, nope]\n", "unknown collector nope"},
+		{"unknown collector from environment", map[string]string{"COLLECTORS": "cluster,nope"}, static, "unknown collector nope"},
+		{"duplicate cluster collector", nil, static + "    collectors: [host, host]\n", "cluster pe-01: duplicate collector host"},
+		{"unknown credential provider", nil, static + "credentials:\n  provider: nope\n", "unknown credential provider nope"},
+		{"file provider without path", map[string]string{"CREDENTIALS_PROVIDER": "file"}, static, "requires a path"},
+		{"cluster credential provider", nil, static + "    credentials:\n      provider: nope\n", "cluster pe-01: unknown credential provider"},
+		{"vault path without vault", nil, static + "    vault_path: pe/01\ncredentials:\n  provider: env\n", "has a vault_path but uses the env credential provider"},
+		{"prism central without url", map[string]string{"PC_CLUSTER_NAME": "pc"}, "", "prism central requires a name and a url"},
+		{"prism central api version", map[string]string{"PC_CLUSTER_NAME": "pc", "PC_CLUSTER_URL": "https://pc:9440", "PC_API_VERSION": "v2"}, "", "unsupported prism central api version v2"},
+		{"prism central credentials", nil, "prism_central:\n  name: pc\n  url: https://pc:9440\n  credentials:\n    provider: file\n", "prism central: the file credential provider requires a path"},
+		{"federation only without regions", map[string]string{"FEDERATION_ONLY": "true"}, "", "federation-only mode requires a federation config"},
+		{"zero watchdog interval", nil, static + "watchdog_interval: 0s\n", "watchdog_interval must be positive"},
+		{"negative collection interval", nil, static + "collection_interval: -1m\n", "collection_interval must not be negative"},
+		{"negative quarantine probe interval", nil, static + "quarantine_probe_interval: -5m\n", "quarantine_probe_interval must be positive"},
+		{"zero dns timeout", nil, static + "dns_timeout: 0s\n", "dns_timeout must be positive"},
+		{"negative cache ttl", nil, static + "metrics_cache_ttl: -1s\n", "metrics_cache_ttl must not be negative"},
+		{"negative refresh interval", map[string]string{"PC_CLUSTER_NAME": "pc", "PC_CLUSTER_URL": "https://pc:9440"}, "prism_central:\n  refresh_interval: -1m\n", "prism_central.refresh_interval must not be negative"},
+		{"negative concurrency limit", nil, static + "max_concurrent_collections: -1\n", "max_concurrent_collections must not be negative"},
+		{"ranges checked in federation-only mode", map[string]string{"FEDERATION_ONLY": "true", "FEDERATION_CONFIG": "/configs/regions.yaml"}, "watchdog_interval: 0s\n", "watchdog_interval must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfigEnv(t, tt.env)
+			_, err := LoadConfig(writeConfig(t, tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	setConfigEnv(t, nil)
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("LoadConfig() of a missing file succeeded")
+	}
+}
@@ -17,14 +17,15 @@ package exporter
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/auth"
@@ -32,6 +33,7 @@ import (
 	"github.com/ingka-group/nutanix-exporter/internal/prom"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 const (
@@ -40,163 +42,599 @@ const (
 )
 
 var (
-	ClusterPrefix string
-	PCApiVersion  string
-	VaultClient   *auth.VaultClient
-	ClustersMap   map[string]*nutanix.Cluster
-	clustersMu    sync.RWMutex // Protects ClustersMap
+	PCApiVersion string            // Set at startup, Prism Central is not reloaded
+	VaultClient  *auth.VaultClient // Nil unless a credential provider uses Vault
+	ClustersMap  map[string]*nutanix.Cluster
+	clustersMu   sync.RWMutex // Protects ClustersMap, clustersUpdatedAt and the static and discovered clusters
+
+	clustersUpdatedAt  time.Time                   // Last time the cluster list changed
+	staticTargets      []ClusterTarget             // Clusters in the configuration, including those that failed to initialize
+	staticClusters     map[string]*nutanix.Cluster // Clusters from the configuration
+	discoveredClusters map[string]*nutanix.Cluster // Clusters discovered in Prism Central
+
+	ConfigPath     string                        // Config file the exporter was started with, empty for environment only
+	reloadMu       sync.Mutex                    // Serializes configuration reloads
+	reloadClusters func(targets []ClusterTarget) // Rebuilds the static clusters, set once Vault is available
 )
 
-func Init() {
+func Init(configPath, webConfigFile string) {
 
-	// Get environment variables
-	PCClusterName := getEnvOrFatal("PC_CLUSTER_NAME")
-	PCClusterURL := getEnvOrFatal("PC_CLUSTER_URL")
-	PCApiVersion := os.Getenv("PC_API_VERSION") // Optional, defaults to v4
-	if PCApiVersion == "" {
-		PCApiVersion = "v4"
+	ConfigPath = configPath
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := applyConfig(config); err != nil {
+		log.Fatalf("Failed to apply configuration: %v", err)
 	}
-	ClusterPrefix = os.Getenv("CLUSTER_PREFIX") // Optional
 
-	clusterRefreshIntervalStr := os.Getenv("CLUSTER_REFRESH_INTERVAL")
-	clusterRefreshInterval := 0
-	if clusterRefreshIntervalStr != "" {
-		if v, err := strconv.Atoi(clusterRefreshIntervalStr); err == nil && v > 0 {
-			clusterRefreshInterval = v
-		}
+	// The server is set up first, so an invalid TLS configuration fails before connecting to anything
+	if webConfigFile != "" {
+		config.WebConfigFile = webConfigFile
+	}
+	srv, err := newServer(config)
+	if err != nil {
+		log.Fatalf("Failed to configure HTTP server: %v", err)
 	}
 
-	vaultRefreshIntervalStr := os.Getenv("VAULT_REFRESH_INTERVAL")
-	vaultRefreshInterval := 0
-	if vaultRefreshIntervalStr != "" {
-		if v, err := strconv.Atoi(vaultRefreshIntervalStr); err == nil && v > 0 {
-			vaultRefreshInterval = v
-		}
+	// An aggregator only re-exposes downstream exporters and needs no Vault or Prism Central access
+	if config.FederationOnly {
+		log.Printf("Running in federation-only mode")
+		http.HandleFunc("/", indexHandler)
+		registerTelemetry()
+		registerLifecycleHandlers()
+		registerFederation(config.FederationConfig)
+		markStarted()
+		startServer(srv)
+		return
 	}
 
-	log.Printf("Initializing Vault client")
-	vaultClient, err := auth.NewVaultClient()
-	if err != nil {
-		log.Fatalf("Failed to create Vault client: %v", err)
+	// Custom DNS servers for connections to Nutanix clusters
+	if len(config.DNSServers) > 0 {
+		nutanix.SetResolver(config.DNSServers, config.DNSTimeout)
+	}
+
+	// Watchdog for collections stuck beyond their deadline
+	runBackground(func(ctx context.Context) {
+		prom.CollectionWatchdog.Run(ctx, config.WatchdogInterval)
+	})
+
+	// Vault is only needed if a credential provider uses it
+	if config.usesVault() {
+		log.Printf("Initializing Vault client")
+		VaultClient, err = auth.NewVaultClient()
+		if err != nil {
+			log.Fatalf("Failed to create Vault client: %v", err)
+		}
+		markVaultReachable()
+		vaultCheck = VaultClient.Ping
 	}
 
 	// Periodic refresh of vault client
-	if vaultRefreshInterval > 0 {
-		go func() {
-			ticker := time.NewTicker(time.Duration(vaultRefreshInterval) * time.Second)
+	if VaultClient != nil && config.VaultRefreshInterval > 0 {
+		runBackground(func(ctx context.Context) {
+			ticker := time.NewTicker(config.VaultRefreshInterval)
 			defer ticker.Stop()
 
-			for range ticker.C {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				done := trackIteration("vault refresh")
 				log.Printf("Refreshing Vault client...")
-				vaultClient, err = auth.NewVaultClient()
-				if err != nil {
-					log.Fatalf("Failed to refresh Vault client: %v", err)
+				// A failed login keeps the current token, readiness reports Vault as unreachable until a login succeeds
+				if err := VaultClient.Login(); err != nil {
+					log.Printf("Failed to refresh Vault client: %v", err)
+				} else {
+					markVaultReachable()
 				}
+				done()
 			}
-		}()
+		})
 	}
 
-	log.Printf("Connecting to Prism Central")
-	PCCluster := nutanix.NewCluster(PCClusterName, PCClusterURL, vaultClient, true, true, 10*time.Second)
-	if PCCluster == nil {
-		log.Fatalf("Failed to connect to Prism Central cluster")
+	// Static clusters are served in addition to the clusters discovered in Prism Central
+	if len(config.Clusters) > 0 {
+		log.Printf("Initializing %d static clusters", len(config.Clusters))
+		setStaticClusters(config.Clusters, buildClusters(config.Clusters))
 	}
-
-	// Initial setup of cluster list
-	log.Printf("Initializing clusters")
-	clusterMap, err := SetupClusters(PCCluster, vaultClient, PCApiVersion)
-	if err != nil {
-		log.Fatalf("Failed to initialize clusters: %v", err)
+	reloadMu.Lock()
+	reloadClusters = func(targets []ClusterTarget) {
+		setStaticClusters(targets, buildClusters(targets))
 	}
-	clustersMu.Lock()
-	ClustersMap = clusterMap
-	clustersMu.Unlock()
+	reloadMu.Unlock()
 
-	// Periodic refresh of clusters
-	if clusterRefreshInterval > 0 {
-		go func() {
-			ticker := time.NewTicker(time.Duration(clusterRefreshInterval) * time.Second)
-			defer ticker.Stop()
-			for range ticker.C { // Every time the ticker ticks, i.e. every refreshInterval secs, exec code below
-				log.Printf("Refreshing cluster list...")
-				newMap, err := SetupClusters(PCCluster, vaultClient, PCApiVersion)
-				if err != nil {
-					log.Printf("Cluster refresh failed: %v", err)
-					continue // wait for next tick and try again
-				}
-				clustersMu.Lock()
-				ClustersMap = newMap
-				clustersMu.Unlock()
-				log.Printf("Cluster list refreshed")
+	if pc := config.PrismCentral; pc != nil {
+		PCApiVersion = pc.APIVersion
+		pcCredentials := config.Credentials
+		if pc.Credentials != nil {
+			pcCredentials = *pc.Credentials
+		}
+		pcProvider, err := newCredentialProvider(pcCredentials, "", true)
+		if err != nil {
+			log.Fatalf("Failed to set up Prism Central credentials: %v", err)
+		}
+
+		log.Printf("Connecting to Prism Central")
+		PCCluster := nutanix.NewCluster(pc.Name, "", pc.URL, pcProvider, true, true, DefaultClusterTimeout)
+		if PCCluster == nil && pc.SeedFile == "" {
+			log.Fatalf("Failed to connect to Prism Central cluster")
+		}
+		// The refresh loop reconnects while the readiness and self-test handlers read the connection
+		var pcConnection atomic.Pointer[nutanix.Cluster]
+		pcConnection.Store(PCCluster)
+		pcCheck = func() error {
+			pcCluster := pcConnection.Load()
+			if pcCluster == nil {
+				return fmt.Errorf("not connected to Prism Central")
+			}
+			return pingPrismCentral(pcCluster, PCApiVersion)
+		}
+		pcDiscovery = func() ([]ClusterTarget, error) {
+			pcCluster := pcConnection.Load()
+			if pcCluster == nil {
+				return nil, fmt.Errorf("not connected to Prism Central")
+			}
+			return FetchClusters(pcCluster, PCApiVersion)
+		}
+
+		// Initial setup of cluster list, falling back to the seed file if Prism Central is unreachable
+		log.Printf("Initializing clusters")
+		fromSeed := false
+		var clusterMap map[string]*nutanix.Cluster
+		if PCCluster != nil {
+			clusterMap, err = SetupClusters(PCCluster, PCApiVersion)
+		} else {
+			err = fmt.Errorf("failed to connect to Prism Central cluster")
+		}
+		if err != nil {
+			if pc.SeedFile == "" {
+				log.Fatalf("Failed to initialize clusters: %v", err)
+			}
+			log.Printf("Failed to initialize clusters from Prism Central: %v, falling back to seed file %s", err, pc.SeedFile)
+			targets, seedErr := LoadSeed(pc.SeedFile)
+			if seedErr != nil {
+				log.Fatalf("Failed to load seed file: %v", seedErr)
 			}
-		}()
+			clusterMap = buildClusters(targets)
+			fromSeed = true
+		} else {
+			markPCReachable()
+		}
+		setDiscoveredClusters(clusterMap)
+
+		// Periodic refresh of clusters. When running from the seed file, Prism Central is retried even
+		// without a refresh interval until discovery succeeds once.
+		refreshInterval := pc.RefreshInterval
+		if fromSeed && refreshInterval == 0 {
+			refreshInterval = DefaultSeedRetryInterval
+		}
+		if refreshInterval > 0 {
+			runBackground(func(ctx context.Context) {
+				ticker := time.NewTicker(refreshInterval)
+				defer ticker.Stop()
+				for { // Every time the ticker ticks, i.e. every refreshInterval secs, exec code below
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+					done := trackIteration("cluster refresh")
+					if PCCluster == nil {
+						log.Printf("Reconnecting to Prism Central...")
+						if PCCluster = nutanix.NewCluster(pc.Name, "", pc.URL, pcProvider, true, true, DefaultClusterTimeout); PCCluster == nil {
+							clusterRefreshFailures.Inc()
+							log.Printf("Failed to connect to Prism Central cluster")
+							done()
+							continue
+						}
+						pcConnection.Store(PCCluster)
+					}
+					PCCluster.RefreshCredentialsIfNeeded()
+					log.Printf("Refreshing cluster list...")
+					newMap, err := SetupClusters(PCCluster, PCApiVersion)
+					if err != nil {
+						clusterRefreshFailures.Inc()
+						log.Printf("Cluster refresh failed: %v", err)
+						done()
+						continue // wait for next tick and try again
+					}
+					setDiscoveredClusters(newMap)
+					markPCReachable()
+					log.Printf("Cluster list refreshed")
+					done()
+
+					// Retrying was only needed to get off the seed file
+					if pc.RefreshInterval == 0 {
+						return
+					}
+				}
+			})
+		}
+	}
+	// Background collection, scrapes are served from the latest collection
+	if config.CollectionInterval > 0 {
+		log.Printf("Collecting clusters in the background every %s", config.CollectionInterval)
+		CollectionInterval = config.CollectionInterval
+		runBackground(runCollection)
+	}
+	markStarted()
+
+	// Tenant-scoped metrics, only enabled when tenants are configured. The endpoints serving any
+	// cluster are then restricted to the admin, so that tenants can't read each other's clusters.
+	var tenants map[string]*Tenant
+	if config.TenantsConfig != "" {
+		log.Printf("Loading tenants from %s", config.TenantsConfig)
+		if tenants, err = LoadTenants(config.TenantsConfig); err != nil {
+			log.Fatalf("Failed to load tenants: %v", err)
+		}
+		tenantsEnabled = true
 	}
 
 	log.Printf("Initializing HTTP server")
 	http.HandleFunc("/", indexHandler)
+	registerTelemetry()
+	registerLifecycleHandlers()
+	http.HandleFunc("/-/selftest", allClusters(selfTestHandler))
 
 	// Dynamically create metrics-serving handler for incoming http request
-	http.HandleFunc("/metrics/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/metrics/", allClusters(func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(r.URL.Path, "/metrics/")
-		clustersMu.RLock()
-		cluster, ok := ClustersMap[name]
-		clustersMu.RUnlock()
+		cluster, ok := lookupCluster(name)
 		if !ok {
 			http.NotFound(w, r)
 			return
 		}
-		createClusterMetricsHandler(cluster, vaultClient)(w, r) // produce handler function for the incoming http request and execute it immediately
-	})
+		createClusterMetricsHandler(cluster)(w, r) // produce handler function for the incoming http request and execute it immediately
+	}))
+
+	// Blackbox exporter style probing, so Prometheus selects the cluster and collectors via relabeling
+	http.HandleFunc("/probe", allClusters(probeHandler))
+
+	// Rollups across all clusters
+	http.HandleFunc("/metrics/fleet", allClusters(fleetMetricsHandler))
+
+	if tenants != nil {
+		http.HandleFunc("/tenants/", tenantMetricsHandler(tenants))
+	}
+
+	// Aggregated metrics for groups of clusters, only enabled when groups are configured
+	if config.GroupsConfig != "" {
+		log.Printf("Loading cluster groups from %s", config.GroupsConfig)
+		groups, err := LoadGroups(config.GroupsConfig)
+		if err != nil {
+			log.Fatalf("Failed to load cluster groups: %v", err)
+		}
+		http.HandleFunc("/metrics/group/", allClusters(groupMetricsHandler(groups)))
+	}
+
+	// List of served clusters, used by federating aggregators
+	http.HandleFunc("/api/clusters", allClusters(clustersAPIHandler))
+	if config.FederationConfig != "" {
+		registerFederation(config.FederationConfig)
+	}
+
+	startServer(srv)
+}
+
+// applyConfig applies the settings that can change without a restart
+func applyConfig(config *Config) error {
+	// Scheduled windows without collection, e.g. for network maintenance
+	var blackouts []*Blackout
+	if config.BlackoutConfig != "" {
+		log.Printf("Loading blackout windows from %s", config.BlackoutConfig)
+		var err error
+		if blackouts, err = LoadBlackouts(config.BlackoutConfig); err != nil {
+			return fmt.Errorf("failed to load blackout windows: %v", err)
+		}
+	}
+	// Handlers and background goroutines read the settings concurrently, so they are replaced as a whole
+	settings.Store(newSettings(config, blackouts))
+
+	setMaxConcurrentCollections(config.MaxConcurrentCollections)
+	nutanix.SetMaxClusterRequests(config.MaxClusterRequests)
+	nutanix.SetReloginInterval(config.ReloginInterval)
+	prom.CollectionWatchdog.Configure(config.WatchdogGrace, config.WatchdogRecycleClient)
+	return nil
+}
+
+// Reload re-reads the configuration and applies it without restarting
+// The static clusters are rebuilt, discovered clusters pick up the changes on their next refresh.
+// Prism Central, Vault, DNS, the listen address and the endpoint configs are only read at startup.
+func Reload() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	log.Printf("Reloading configuration")
+	config, err := LoadConfig(ConfigPath)
+	if err != nil {
+		log.Printf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+	if err := applyConfig(config); err != nil {
+		log.Printf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+	if reloadClusters != nil {
+		reloadClusters(config.Clusters)
+	}
+	log.Printf("Configuration reloaded")
+}
+
+// registerFederation registers the /federate endpoint for the downstream exporters in the given file
+func registerFederation(federationConfig string) {
+	log.Printf("Loading federated regions from %s", federationConfig)
+	regions, err := LoadRegions(federationConfig)
+	if err != nil {
+		log.Fatalf("Failed to load federated regions: %v", err)
+	}
+	http.HandleFunc("/federate", allClusters(federationHandler(regions)))
+}
+
+// newServer creates the HTTP server of the registered handlers
+// TLS, client certificate verification and response headers come from the web config file, if one is configured
+func newServer(config *Config) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              config.ListenAddress,
+		Handler:           http.DefaultServeMux,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	if config.WebConfigFile == "" {
+		return srv, nil
+	}
+
+	log.Printf("Loading web config from %s", config.WebConfigFile)
+	web, err := LoadWebConfig(config.WebConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	srv.Handler = withHeaders(srv.Handler, web.HTTPServerConfig.Headers)
+	if web.TLSServerConfig != nil {
+		if srv.TLSConfig, err = web.TLSServerConfig.tlsConfig(); err != nil {
+			return nil, err
+		}
+		if http2 := web.HTTPServerConfig.HTTP2; http2 != nil && !*http2 {
+			srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
+	return srv, nil
+}
 
-	log.Printf("Starting Server on %s", ListenAddress)
-	if err := http.ListenAndServe(ListenAddress, nil); err != nil {
+// startServer serves the registered handlers until the server fails or is shut down by a drain
+func startServer(srv *http.Server) {
+	server.Store(srv)
+
+	var err error
+	if srv.TLSConfig != nil {
+		log.Printf("Starting Server on %s with TLS", srv.Addr)
+		err = srv.ListenAndServeTLS("", "") // The certificate is provided by the TLS config
+	} else {
+		log.Printf("Starting Server on %s", srv.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Error starting server: %s", err)
 	}
 }
 
+// setStaticClusters replaces the static clusters in the served cluster list
+func setStaticClusters(targets []ClusterTarget, clusters map[string]*nutanix.Cluster) {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	staticTargets = targets
+	staticClusters = clusters
+	publishClusters()
+}
+
+// setDiscoveredClusters replaces the clusters discovered in Prism Central in the served cluster list
+func setDiscoveredClusters(clusters map[string]*nutanix.Cluster) {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+	discoveredClusters = clusters
+	publishClusters()
+}
+
+// publishClusters merges the static and discovered clusters into the served cluster list
+// A static cluster takes precedence over a discovered cluster with the same name or UUID
+// clustersMu must be held
+func publishClusters() {
+	clusters := make(map[string]*nutanix.Cluster, len(staticClusters)+len(discoveredClusters))
+	staticNames := make(map[string]bool, len(staticClusters))
+	for id, cluster := range staticClusters {
+		clusters[id] = cluster
+		staticNames[cluster.Name] = true
+	}
+	for id, cluster := range discoveredClusters {
+		if _, exists := clusters[id]; exists || staticNames[cluster.Name] {
+			continue
+		}
+		clusters[id] = cluster
+	}
+	forgetClusters(ClustersMap, clusters)
+	ClustersMap = clusters
+	clustersUpdatedAt = time.Now()
+}
+
+// lookupCluster returns the served cluster with the given UUID or name
+func lookupCluster(nameOrUUID string) (*nutanix.Cluster, bool) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	if cluster, ok := ClustersMap[nameOrUUID]; ok {
+		return cluster, true
+	}
+	for _, cluster := range ClustersMap {
+		if cluster.Name == nameOrUUID {
+			return cluster, true
+		}
+	}
+	return nil, false
+}
+
+// ClusterTarget is a Prism Element cluster, either discovered in Prism Central or configured statically
+type ClusterTarget struct {
+	Name       string        `yaml:"name"`
+	UUID       string        `yaml:"uuid,omitempty"`
+	URL        string        `yaml:"url"`
+	VaultPath  string        `yaml:"vault_path,omitempty"` // Vault path of the credentials, defaults to the path derived from the name
+	Collectors []string      `yaml:"collectors,omitempty"` // Defaults to the global collectors setting
+	Timeout    time.Duration `yaml:"timeout,omitempty"`    // Defaults to DefaultClusterTimeout
+
+	Credentials *CredentialsConfig `yaml:"credentials,omitempty"` // Defaults to the global credential provider
+}
+
+// DefaultCollectors are the collectors registered for clusters without a collector selection, unless configured otherwise
+var DefaultCollectors = []string{"storage_container", "cluster", "host", "vm"}
+
+// collectorFactories creates the collectors that can be selected per cluster, by name
+var collectorFactories = map[string]func(cluster *nutanix.Cluster) prometheus.Collector{
+	"storage_container": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewStorageContainerCollector(cluster, "configs/storage_container.yaml")
+	},
+	"cluster": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewClusterCollector(cluster, "configs/cluster.yaml")
+	},
+	"host": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewHostCollector(cluster, "configs/host.yaml")
+	},
+	"vm": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewVMCollector(cluster, "configs/vm.yaml")
+	},
+	"vm_disk": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewVMDiskCollector(cluster, "configs/vm_disk.yaml")
+	},
+	"alert": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewAlertCollector(cluster, "configs/alert.yaml")
+	},
+	"disk": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewDiskCollector(cluster, "configs/disk.yaml")
+	},
+	"protection_domain": func(cluster *nutanix.Cluster) prometheus.Collector {
+		return prom.NewProtectionDomainCollector(cluster, "configs/protection_domain.yaml")
+	},
+}
+
 // SetupClusters creates Prometheus collectors for every cluster registered in Prism Central
-func SetupClusters(prismClient *nutanix.Cluster, vaultClient *auth.VaultClient, PCApiVersion string) (map[string]*nutanix.Cluster, error) {
-	clusterData, err := FetchClusters(prismClient, PCApiVersion)
+// The discovered list is persisted to the seed file if one is configured
+func SetupClusters(prismClient *nutanix.Cluster, PCApiVersion string) (map[string]*nutanix.Cluster, error) {
+	targets, err := FetchClusters(prismClient, PCApiVersion)
 	if err != nil {
 		return nil, err // Propagate the error up
 	}
 
+	if seedFile := current().SeedFile; seedFile != "" {
+		if err := SaveSeed(seedFile, targets); err != nil {
+			log.Printf("Failed to save seed file %s: %v", seedFile, err)
+		}
+	}
+
+	return buildClusters(targets), nil
+}
+
+// buildClusters creates Prometheus collectors for every target
+// The returned map is keyed by cluster UUID, so a renamed cluster keeps its identity
+func buildClusters(targets []ClusterTarget) map[string]*nutanix.Cluster {
+	s := current()
 	clustersMap := make(map[string]*nutanix.Cluster)
-	for name, url := range clusterData {
-		cluster := nutanix.NewCluster(name, url, vaultClient, false, true, 10*time.Second)
+	for _, target := range targets {
+		name := target.Name
+		timeout := target.Timeout
+		if timeout == 0 {
+			timeout = DefaultClusterTimeout
+		}
+		credentials := s.Credentials
+		if target.Credentials != nil {
+			credentials = *target.Credentials
+		}
+		provider, err := newCredentialProvider(credentials, target.VaultPath, false)
+		if err != nil {
+			log.Printf("Failed to initialize cluster %s: %v", name, err)
+			continue
+		}
+		cluster := nutanix.NewCluster(name, target.UUID, target.URL, provider, false, true, timeout)
 		if cluster == nil {
 			log.Printf("Failed to initialize cluster %s", name)
 			continue
 		}
 
-		// Register collectors for this cluster
+		// Register collectors for this cluster, recovering from panics so one bad response can't crash the exporter
 		log.Printf("Registering collectors for cluster %s", name)
-		collectors := []prometheus.Collector{
-			prom.NewStorageContainerCollector(cluster, "configs/storage_container.yaml"),
-			prom.NewClusterCollector(cluster, "configs/cluster.yaml"),
-			prom.NewHostCollector(cluster, "configs/host.yaml"),
-			prom.NewVMCollector(cluster, "configs/vm.yaml"),
+		names := target.Collectors
+		if len(names) == 0 {
+			names = s.EnabledCollectors
+			if s.VMDiskMetrics && !slices.Contains(names, "vm_disk") {
+				names = append(names[:len(names):len(names)], "vm_disk")
+			}
+		}
+		collectors := make([]prometheus.Collector, 0, len(names))
+		for _, collectorName := range names {
+			collectors = append(collectors, prom.WithRecovery(cluster, collectorName, collectorFactories[collectorName](cluster)))
 		}
 
 		for _, collector := range collectors {
 			cluster.Registry.MustRegister(collector)
 		}
 		cluster.Collectors = collectors
+		cluster.Registry.MustRegister(statusCollectors(cluster)...)
 
 		// Add the cluster to the map
-		clustersMap[name] = cluster
+		clustersMap[cluster.ID()] = cluster
 	}
 
-	return clustersMap, nil
+	return clustersMap
+}
+
+// newCredentialProvider creates the credential provider of a cluster
+// vaultPath optionally overrides the Vault secret path derived from the cluster name
+func newCredentialProvider(c CredentialsConfig, vaultPath string, isPC bool) (auth.CredentialProvider, error) {
+	switch c.Provider {
+	case "file":
+		return &auth.FileProvider{Dir: c.Path}, nil
+	case "env":
+		return &auth.EnvProvider{Prefix: c.Prefix}, nil
+	default:
+		if VaultClient == nil {
+			return nil, fmt.Errorf("the vault credential provider is used but Vault was not initialized at startup")
+		}
+		account := auth.PETaskAccount
+		if isPC {
+			account = auth.PCTaskAccount
+		}
+		return &auth.VaultProvider{Client: VaultClient, Account: account, Path: vaultPath}, nil
+	}
 }
 
-// FetchClusters fetches the name and IP of all Prism Element clusters registered in Prism Central.
+// statusCollectors returns the collectors of the exporter's own metrics for the cluster
+func statusCollectors(cluster *nutanix.Cluster) []prometheus.Collector {
+	s := current()
+	collectors := []prometheus.Collector{prom.NewStatsCollector(cluster)}
+	if s.QuarantineThreshold > 0 {
+		collectors = append(collectors, &quarantineCollector{cluster: cluster})
+	}
+	if len(s.Blackouts) > 0 {
+		collectors = append(collectors, &blackoutCollector{cluster: cluster})
+	}
+	return collectors
+}
+
+// statusRegistry returns a registry with only the exporter's own metrics for the cluster
+// It is served instead of the cluster's metrics while collection is suppressed
+func statusRegistry(cluster *nutanix.Cluster) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(statusCollectors(cluster)...)
+	return registry
+}
+
+// FetchClusters fetches the name, UUID and IP of all Prism Element clusters registered in Prism Central.
 // Takes a version flag to switch between v3 and v4 API calls. Skips clusters that don't match the prefix if provided.
-func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]string, error) {
+func FetchClusters(prismClient *nutanix.Cluster, version string) ([]ClusterTarget, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	clusterData := make(map[string]string)
+	var targets []ClusterTarget
 
 	// Define the functions for making requests and parsing for both v3 and v4.
 
@@ -244,9 +682,11 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 			if !ipOk {
 				continue
 			}
+			uuid, _ := clusterMap["extId"].(string)
 
 			clusters = append(clusters, map[string]string{
 				"name": name,
+				"uuid": uuid,
 				"ip":   ip,
 			})
 		}
@@ -287,8 +727,14 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 				continue
 			}
 
+			var uuid string
+			if metadata, metadataOk := cluster["metadata"].(map[string]interface{}); metadataOk {
+				uuid, _ = metadata["uuid"].(string)
+			}
+
 			clusters = append(clusters, map[string]string{
 				"name": name,
+				"uuid": uuid,
 				"ip":   ip,
 			})
 		}
@@ -327,32 +773,75 @@ func FetchClusters(prismClient *nutanix.Cluster, version string) (map[string]str
 		return nil, err
 	}
 
-	// Build the final clusterData map
+	// Build the final list of targets
 	for _, cluster := range clusters {
 		name := cluster["name"]
 		ip := cluster["ip"]
 
 		// Skip clusters that don't match the prefix if provided
-		if ClusterPrefix != "" && !strings.HasPrefix(name, ClusterPrefix) {
+		if prefix := current().ClusterPrefix; prefix != "" && !strings.HasPrefix(name, prefix) {
 			log.Printf("Skipping cluster %s", name)
 			continue
 		}
 
-		clusterData[name] = fmt.Sprintf("https://%s:9440", ip)
-		log.Printf("Found cluster %s at %s", name, clusterData[name])
+		target := ClusterTarget{
+			Name: name,
+			UUID: cluster["uuid"],
+			URL:  fmt.Sprintf("https://%s:9440", ip),
+		}
+		targets = append(targets, target)
+		log.Printf("Found cluster %s (%s) at %s", target.Name, target.UUID, target.URL)
 	}
 
-	return clusterData, nil
+	return targets, nil
+}
+
+// pingPrismCentral makes a lightweight authenticated request to Prism Central
+func pingPrismCentral(prismClient *nutanix.Cluster, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var resp *http.Response
+	var err error
+	switch version {
+	case "v3":
+		resp, err = prismClient.API.MakeRequestWithParams(ctx, "POST", "/api/nutanix/v3/clusters/list", nutanix.RequestParams{
+			Payload: map[string]interface{}{"kind": "cluster", "length": 1},
+		})
+	case "v4b1":
+		resp, err = prismClient.API.MakeRequest(ctx, "GET", "/api/clustermgmt/v4.0.b1/config/clusters?$limit=1")
+	default:
+		resp, err = prismClient.API.MakeRequest(ctx, "GET", "/api/clustermgmt/v4.0/config/clusters?$limit=1")
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	return nil
 }
 
 // createClusterMetricsHandler returns a http.HandlerFunc that serves metrics for a specific cluster
-func createClusterMetricsHandler(cluster *nutanix.Cluster, vaultClient *auth.VaultClient) http.HandlerFunc {
+func createClusterMetricsHandler(cluster *nutanix.Cluster) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Refresh credentials for the specific cluster
-		cluster.RefreshCredentialsIfNeeded(vaultClient)
+		cluster.RefreshCredentialsIfNeeded()
 
-		// Serve metrics from the specific cluster's registry
-		promhttp.HandlerFor(cluster.Registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		// Serve metrics from the specific cluster's registry, cached metrics let pollers skip unchanged responses
+		families, collectedAt, err := gatherCluster(r.Context(), cluster)
+		if maxAge, ok := cacheMaxAge(collectedAt); ok && checkNotModified(w, r, collectedAt, maxAge) {
+			return
+		}
+		lastCollection := prometheus.NewRegistry()
+		lastCollection.MustRegister(&lastCollectionCollector{cluster: cluster})
+		gatherer := prometheus.Gatherers{
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, err }),
+			lastCollection,
+		}
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 	}
 }
 
@@ -360,12 +849,3 @@ func createClusterMetricsHandler(cluster *nutanix.Cluster, vaultClient *auth.Vau
 func indexHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, `<html><head><title>Nutanix Exporter</title></head><body><h1>Nutanix Exporter</h1><p><a href="/metrics">Metrics</a></p></body></html>`)
 }
-
-// getEnvOrFatal returns the value of the specified environment variable or exits
-func getEnvOrFatal(envVar string) string {
-	value := os.Getenv(envVar)
-	if value == "" {
-		log.Fatalf("%s environment variable is not set", envVar)
-	}
-	return value
-}
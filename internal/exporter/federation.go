@@ -0,0 +1,281 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultFederationTimeout = 30 * time.Second
+
+// Region is a downstream exporter instance whose metrics are re-exposed by the aggregator
+type Region struct {
+	Name     string        `yaml:"region"`
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"` // Optional basic auth for the downstream exporter
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"` // Defaults to DefaultFederationTimeout
+
+	TLSConfig RegionTLSConfig `yaml:"tls_config"` // TLS settings for https URLs
+
+	client *http.Client // Dedicated client of the region, created by LoadRegions
+}
+
+// RegionTLSConfig configures how the aggregator connects to a downstream exporter served over TLS
+type RegionTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`   // CA bundle to verify the exporter's certificate, defaults to the system roots
+	CertFile           string `yaml:"cert_file"` // Client certificate for exporters that require one
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// tlsConfig builds the client TLS configuration of the region
+// The client certificate is read on every handshake, so renewed certificates are used without a restart
+func (c *RegionTLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("tls_config requires both a cert_file and a key_file")
+	}
+	if c.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+			}
+			return &cert, nil
+		}
+	}
+
+	return config, nil
+}
+
+// LoadRegions reads the downstream exporter definitions from the given YAML file
+func LoadRegions(configPath string) ([]*Region, error) {
+	yamlFile, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []*Region
+	if err := yaml.Unmarshal(yamlFile, &regions); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, region := range regions {
+		if region.Name == "" || region.URL == "" {
+			return nil, fmt.Errorf("region without a name or url in %s", configPath)
+		}
+		if seen[region.Name] {
+			return nil, fmt.Errorf("duplicate region %s in %s", region.Name, configPath)
+		}
+		seen[region.Name] = true
+		if region.Timeout <= 0 {
+			region.Timeout = DefaultFederationTimeout
+		}
+		tlsConfig, err := region.TLSConfig.tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("region %s in %s: %v", region.Name, configPath, err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		region.client = &http.Client{Transport: transport}
+		log.Printf("Loaded region %s at %s", region.Name, region.URL)
+	}
+
+	return regions, nil
+}
+
+// get performs an authenticated GET request against the downstream exporter
+func (r *Region) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(r.URL, "/")+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request to %s failed: %s", req.URL, resp.Status)
+	}
+	return resp, nil
+}
+
+// fetchClusters lists the clusters served by the downstream exporter
+func (r *Region) fetchClusters(ctx context.Context) ([]ClusterSummary, error) {
+	resp, err := r.get(ctx, "/api/clusters", "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var clusters []ClusterSummary
+	if err := json.NewDecoder(resp.Body).Decode(&clusters); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// fetchMetrics scrapes the metrics of one cluster from the downstream exporter
+func (r *Region) fetchMetrics(ctx context.Context, cluster string) (map[string]*dto.MetricFamily, error) {
+	resp, err := r.get(ctx, "/metrics/"+url.PathEscape(cluster), string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// federationCollector scrapes every region at collect time and adds a region label to the metrics
+type federationCollector struct {
+	regions []*Region
+}
+
+// Describe is intentionally empty, the federated metrics depend on what the regions return
+func (c *federationCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect scrapes every cluster of every region concurrently
+func (c *federationCollector) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, region := range c.regions {
+		wg.Add(1)
+		go func(region *Region) {
+			defer wg.Done()
+			c.collectRegion(region, ch)
+		}(region)
+	}
+	wg.Wait()
+}
+
+// collectRegion scrapes the clusters of one region and re-emits their metrics
+func (c *federationCollector) collectRegion(region *Region, ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), region.Timeout)
+	defer cancel()
+
+	clusters, err := region.fetchClusters(ctx)
+	if err != nil {
+		log.Printf("Error fetching clusters for region %s: %v", region.Name, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			families, err := region.fetchMetrics(ctx, cluster)
+			if err != nil {
+				log.Printf("Error fetching metrics for cluster %s in region %s: %v", cluster, region.Name, err)
+				return
+			}
+			for _, family := range families {
+				emitWithRegion(family, region.Name, ch)
+			}
+		}(cluster.Name)
+	}
+	wg.Wait()
+}
+
+// emitWithRegion re-emits a parsed metric family with an added region label
+// Labels already named region are kept as-is, and only gauges, counters and untyped metrics are supported
+func emitWithRegion(family *dto.MetricFamily, region string, ch chan<- prometheus.Metric) {
+	var valueType prometheus.ValueType
+	switch family.GetType() {
+	case dto.MetricType_GAUGE:
+		valueType = prometheus.GaugeValue
+	case dto.MetricType_COUNTER:
+		valueType = prometheus.CounterValue
+	case dto.MetricType_UNTYPED:
+		valueType = prometheus.UntypedValue
+	default:
+		return
+	}
+
+	for _, m := range family.GetMetric() {
+		var labelNames, labelValues []string
+		hasRegion := false
+		for _, label := range m.GetLabel() {
+			labelNames = append(labelNames, label.GetName())
+			labelValues = append(labelValues, label.GetValue())
+			hasRegion = hasRegion || label.GetName() == "region"
+		}
+		if !hasRegion {
+			labelNames = append(labelNames, "region")
+			labelValues = append(labelValues, region)
+		}
+
+		desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), labelNames, nil)
+		metric, err := prometheus.NewConstMetric(desc, valueType, sampleValue(m), labelValues...)
+		if err != nil {
+			log.Printf("Skipping federated metric %s from region %s: %v", family.GetName(), region, err)
+			continue
+		}
+		ch <- metric
+	}
+}
+
+// federationHandler serves /federate with the metrics of every cluster in every region
+func federationHandler(regions []*Region) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&federationCollector{regions: regions})
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// sampleString formats the labels and value of a metric, e.g. `{cluster_name="a",region="eu"} 1`
+func sampleString(m *dto.Metric) string {
+	var labels []string
+	for _, label := range m.GetLabel() {
+		labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("{%s} %g", strings.Join(labels, ","), sampleValue(m))
+}
+
+func TestEmitWithRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     []string
+		wantType dto.MetricType
+	}{
+		{
+			name:     "gauge without labels",
+			input:    "# TYPE nutanix_test gauge\nnutanix_test 1\n",
+			want:     []string{`{region="eu"} 1`},
+			wantType: dto.MetricType_GAUGE,
+		},
+		{
+			name:     "labels are kept",
+			input:    "# TYPE nutanix_test gauge\nnutanix_test{cluster_name=\"a\",cluster_uuid=\"a-uuid\"} 2\n",
+			want:     []string{`{cluster_name="a",cluster_uuid="a-uuid",region="eu"} 2`},
+			wantType: dto.MetricType_GAUGE,
+		},
+		{
+			name:     "existing region label is kept",
+			input:    "# TYPE nutanix_test gauge\nnutanix_test{region=\"us\"} 3\n",
+			want:     []string{`{region="us"} 3`},
+			wantType: dto.MetricType_GAUGE,
+		},
+		{
+			name:     "counter",
+			input:    "# TYPE nutanix_test_total counter\nnutanix_test_total{code=\"200\"} 4\nnutanix_test_total{code=\"500\"} 5\n",
+			want:     []string{`{code="200",region="eu"} 4`, `{code="500",region="eu"} 5`},
+			wantType: dto.MetricType_COUNTER,
+		},
+		{
+			name:     "untyped",
+			input:    "nutanix_test 6\n",
+			want:     []string{`{region="eu"} 6`},
+			wantType: dto.MetricType_UNTYPED,
+		},
+		{
+			name:  "histograms are skipped",
+			input: "# TYPE nutanix_test histogram\nnutanix_test_bucket{le=\"+Inf\"} 1\nnutanix_test_sum 1\nnutanix_test_count 1\n",
+		},
+		{
+			name:  "summaries are skipped",
+			input: "# TYPE nutanix_test summary\nnutanix_test_sum 1\nnutanix_test_count 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parser expfmt.TextParser
+			families, err := parser.TextToMetricFamilies(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ch := make(chan prometheus.Metric, 10)
+			for _, family := range families {
+				emitWithRegion(family, "eu", ch)
+			}
+			close(ch)
+
+			var got []string
+			for metric := range ch {
+				var m dto.Metric
+				if err := metric.Write(&m); err != nil {
+					t.Fatal(err)
+				}
+				metricType := dto.MetricType_UNTYPED
+				switch {
+				case m.GetGauge() != nil:
+					metricType = dto.MetricType_GAUGE
+				case m.GetCounter() != nil:
+					metricType = dto.MetricType_COUNTER
+				}
+				if metricType != tt.wantType {
+					t.Errorf("type = %s, want %s", metricType, tt.wantType)
+				}
+				got = append(got, sampleString(&m))
+			}
+			sort.Strings(got)
+
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestFederationHandler(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "admin" || pass != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/clusters":
+			fmt.Fprint(w, `[{"name":"cluster-a"},{"name":"cluster-b"}]`)
+		case "/metrics/cluster-a", "/metrics/cluster-b":
+			name := strings.TrimPrefix(r.URL.Path, "/metrics/")
+			fmt.Fprintf(w, "# TYPE nutanix_cluster_up gauge\nnutanix_cluster_up{cluster_name=%q} 1\n", name)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer downstream.Close()
+
+	regions := []*Region{
+		{Name: "eu", URL: downstream.URL, Username: "admin", Password: "secret", Timeout: DefaultFederationTimeout, client: downstream.Client()},
+		// Regions that fail are skipped without failing the others
+		{Name: "us", URL: downstream.URL, Username: "admin", Password: "wrong", Timeout: DefaultFederationTimeout, client: downstream.Client()},
+	}
+
+	rec := httptest.NewRecorder()
+	federationHandler(regions)(rec, httptest.NewRequest(http.MethodGet, "/federate", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`nutanix_cluster_up{cluster_name="cluster-a",region="eu"} 1`,
+		`nutanix_cluster_up{cluster_name="cluster-b",region="eu"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("response is missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `region="us"`) {
+		t.Errorf("response contains metrics of the failed region:\n%s", body)
+	}
+}
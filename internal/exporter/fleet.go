@@ -0,0 +1,129 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fleetSums maps fleet-wide metrics onto the per-cluster metrics they are summed from
+var fleetSums = []struct {
+	name   string
+	help   string
+	source string
+}{
+	{"nutanix_fleet_nodes", "Total number of nodes across all clusters.", "nutanix_cluster_num_nodes"},
+	{"nutanix_fleet_vms", "Total number of VMs across all clusters.", "nutanix_vm_grand_total_entities"},
+	{"nutanix_fleet_cpu_capacity_hz", "Total CPU capacity across all clusters in Hz.", "nutanix_host_cpu_capacity_in_hz"},
+	{"nutanix_fleet_memory_capacity_bytes", "Total memory capacity across all clusters in bytes.", "nutanix_host_memory_capacity_in_bytes"},
+	{"nutanix_fleet_storage_capacity_bytes", "Total storage capacity across all clusters in bytes.", "nutanix_host_usage_stats_storage_capacity_bytes"},
+	{"nutanix_fleet_storage_free_bytes", "Total free storage across all clusters in bytes.", "nutanix_host_usage_stats_storage_free_bytes"},
+}
+
+var (
+	fleetClustersDesc = prometheus.NewDesc(
+		"nutanix_fleet_clusters", "Number of discovered clusters.", nil, nil)
+	fleetUnhealthyDesc = prometheus.NewDesc(
+		"nutanix_fleet_clusters_unhealthy", "Number of clusters whose cluster information could not be collected.", nil, nil)
+	fleetVersionDesc = prometheus.NewDesc(
+		"nutanix_fleet_clusters_by_version", "Number of clusters running each AOS version.", []string{"version"}, nil)
+)
+
+// fleetCollector computes rollups across every discovered cluster at collect time
+type fleetCollector struct {
+	ctx context.Context // Context of the request, collections are not waited for after it is done
+}
+
+// Describe sends the descriptors of the fleet metrics
+func (c *fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fleetClustersDesc
+	ch <- fleetUnhealthyDesc
+	ch <- fleetVersionDesc
+	for _, s := range fleetSums {
+		ch <- prometheus.NewDesc(s.name, s.help, nil, nil)
+	}
+}
+
+// Collect gathers every cluster and emits the fleet-wide totals
+func (c *fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	clustersMu.RLock()
+	clusters := make([]*nutanix.Cluster, 0, len(ClustersMap))
+	for _, cluster := range ClustersMap {
+		clusters = append(clusters, cluster)
+	}
+	clustersMu.RUnlock()
+
+	gathered := gatherClusters(c.ctx, clusters)
+
+	// A cluster is unhealthy if its cluster information is missing, i.e. the cluster API could not be reached
+	unhealthy := 0
+	versions := make(map[string]int)
+	for _, cluster := range clusters {
+		version, ok := clusterVersion(gathered[cluster.ID()])
+		if !ok {
+			unhealthy++
+			continue
+		}
+		versions[version]++
+	}
+
+	ch <- prometheus.MustNewConstMetric(fleetClustersDesc, prometheus.GaugeValue, float64(len(clusters)))
+	ch <- prometheus.MustNewConstMetric(fleetUnhealthyDesc, prometheus.GaugeValue, float64(unhealthy))
+	for version, count := range versions {
+		ch <- prometheus.MustNewConstMetric(fleetVersionDesc, prometheus.GaugeValue, float64(count), version)
+	}
+
+	for _, s := range fleetSums {
+		var total float64
+		for _, families := range gathered {
+			if value, ok := sumMetric(families, s.source); ok {
+				total += value
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc(s.name, s.help, nil, nil), prometheus.GaugeValue, total)
+	}
+}
+
+// fleetMetricsHandler serves /metrics/fleet with rollups across all clusters
+func fleetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&fleetCollector{ctx: r.Context()})
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// clusterVersion returns the AOS version label of the nutanix_cluster_info metric
+// Returns false if the cluster did not report its information
+func clusterVersion(families []*dto.MetricFamily) (string, bool) {
+	for _, family := range families {
+		if family.GetName() != "nutanix_cluster_info" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "version" {
+					return label.GetValue(), true
+				}
+			}
+		}
+	}
+	return "", false
+}
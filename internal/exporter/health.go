@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultReadinessWindow   = 5 * time.Minute
+	DefaultLivenessThreshold = 10 * time.Minute
+)
+
+var (
+	startupDone atomic.Bool
+	startupOnce sync.Once
+
+	// Started is closed once the initial discovery is done and Init no longer changes the configuration
+	Started = make(chan struct{})
+
+	// Reachability checks used by the readiness probe, nil when the dependency is not used
+	vaultCheck func() error
+	pcCheck    func() error
+
+	lastVaultSuccess atomic.Int64 // Unix nanoseconds
+	lastPCSuccess    atomic.Int64 // Unix nanoseconds
+	readinessMu      sync.Mutex   // Serializes active reachability checks
+
+	loopsMu      sync.Mutex
+	loopsRunning = make(map[string]time.Time) // Loop name -> start of the running iteration
+)
+
+// markStarted marks the initial discovery as done
+func markStarted() {
+	startupDone.Store(true)
+	startupOnce.Do(func() { close(Started) })
+}
+
+// markVaultReachable records a successful interaction with Vault
+func markVaultReachable() {
+	lastVaultSuccess.Store(time.Now().UnixNano())
+}
+
+// markPCReachable records a successful interaction with Prism Central
+func markPCReachable() {
+	lastPCSuccess.Store(time.Now().UnixNano())
+}
+
+// trackIteration records the start of a background loop iteration for the liveness probe
+// The returned function must be called when the iteration ends
+func trackIteration(name string) func() {
+	loopsMu.Lock()
+	loopsRunning[name] = time.Now()
+	loopsMu.Unlock()
+
+	return func() {
+		loopsMu.Lock()
+		delete(loopsRunning, name)
+		loopsMu.Unlock()
+	}
+}
+
+// startupHandler handles the /-/startup endpoint, succeeding once the initial discovery is done
+func startupHandler(w http.ResponseWriter, r *http.Request) {
+	if !startupDone.Load() {
+		http.Error(w, "Initial discovery not done", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Started")
+}
+
+// readyHandler handles the /-/ready endpoint, succeeding if the instance is not draining
+// and Vault and Prism Central were reachable within the readiness window
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		http.Error(w, "Draining", http.StatusServiceUnavailable)
+		return
+	}
+	if !startupDone.Load() {
+		http.Error(w, "Initial discovery not done", http.StatusServiceUnavailable)
+		return
+	}
+
+	var failures []string
+	if err := checkReachable(vaultCheck, &lastVaultSuccess); err != nil {
+		failures = append(failures, fmt.Sprintf("vault: %v", err))
+	}
+	if err := checkReachable(pcCheck, &lastPCSuccess); err != nil {
+		failures = append(failures, fmt.Sprintf("prism central: %v", err))
+	}
+	if len(failures) > 0 {
+		http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Ready")
+}
+
+// checkReachable returns nil if the dependency succeeded within the readiness window
+// If it did not, the dependency is checked actively and the result recorded
+func checkReachable(check func() error, lastSuccess *atomic.Int64) error {
+	if check == nil {
+		return nil
+	}
+
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+
+	if time.Since(time.Unix(0, lastSuccess.Load())) < current().ReadinessWindow {
+		return nil
+	}
+	if err := check(); err != nil {
+		log.Printf("Readiness check failed: %v", err)
+		return err
+	}
+	lastSuccess.Store(time.Now().UnixNano())
+	return nil
+}
+
+// livenessHandler handles the /-/healthy endpoint, failing if a background loop iteration is stuck
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := current().LivenessThreshold
+	loopsMu.Lock()
+	var stuck []string
+	for name, started := range loopsRunning {
+		if running := time.Since(started); running > threshold {
+			stuck = append(stuck, fmt.Sprintf("%s running for %s", name, running.Round(time.Second)))
+		}
+	}
+	loopsMu.Unlock()
+
+	if len(stuck) > 0 {
+		http.Error(w, strings.Join(stuck, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Healthy")
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultDrainTimeout = 30 * time.Second
+	// DefaultDrainDelay gives load balancers a couple of probe periods to see /-/ready fail before the listener closes
+	DefaultDrainDelay = 15 * time.Second
+)
+
+var (
+	// Quit is closed once the exporter has drained and stopped
+	Quit = make(chan struct{})
+
+	server   atomic.Pointer[http.Server] // Set once the server starts, shut down by a drain from another goroutine
+	draining atomic.Bool
+
+	backgroundCtx, stopBackground = context.WithCancel(context.Background())
+	backgroundWG                  sync.WaitGroup
+)
+
+// runBackground runs fn in a goroutine that is stopped and waited for when the exporter drains
+func runBackground(fn func(ctx context.Context)) {
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		fn(backgroundCtx)
+	}()
+}
+
+// registerLifecycleHandlers registers the probe and drain endpoints
+// /healthz and /readyz are aliases following the Kubernetes naming
+func registerLifecycleHandlers() {
+	http.HandleFunc("/-/startup", startupHandler)
+	http.HandleFunc("/-/ready", readyHandler)
+	http.HandleFunc("/-/healthy", livenessHandler)
+	http.HandleFunc("/healthz", livenessHandler)
+	http.HandleFunc("/readyz", readyHandler)
+	http.HandleFunc("/-/quit", quitHandler)
+}
+
+// Shutdown drains the exporter as POST /-/quit does and returns once it has stopped
+func Shutdown() {
+	if draining.CompareAndSwap(false, true) {
+		go drain()
+	}
+	<-Quit
+}
+
+// quitHandler handles POST /-/quit, starting a graceful drain of the exporter
+func quitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	if !draining.CompareAndSwap(false, true) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "Already draining")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "Draining")
+
+	// Drain asynchronously, shutting down the server waits for this request to complete
+	go drain()
+}
+
+// authorizeAdmin checks the request against the admin credentials and writes an error response if it fails
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	s := current()
+	if s.AdminUsername == "" && s.AdminPassword == "" {
+		http.Error(w, "Administrative endpoints are disabled, no admin credentials configured", http.StatusForbidden)
+		return false
+	}
+	if !checkBasicAuth(r, s.AdminUsername, s.AdminPassword) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// drain waits for load balancers to notice the instance is not ready, waits for in-flight
+// scrapes to finish, stops the background goroutines and signals Quit
+func drain() {
+	log.Printf("Draining: instance marked not ready")
+	s := current()
+	time.Sleep(s.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	defer cancel()
+
+	// Shutdown stops accepting connections and waits for in-flight requests
+	log.Printf("Draining: waiting for in-flight scrapes")
+	if srv := server.Load(); srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Draining: in-flight scrapes did not finish in time: %v", err)
+		}
+	}
+
+	log.Printf("Draining: stopping background collectors")
+	stopBackground()
+	stopped := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("Draining: background collectors did not stop in time")
+	}
+
+	log.Printf("Drained, exiting")
+	close(Quit)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// probeHandler serves /probe?target=<cluster>&collect[]=<collector>, in the style of the blackbox exporter
+// The target is a cluster name or UUID. Without collect[] every collector of the cluster is collected.
+// Unknown targets and failed collections are reported with nutanix_probe_success 0 instead of an HTTP error.
+func probeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	names := r.URL.Query()["collect[]"]
+	for _, name := range names {
+		if _, ok := collectorFactories[name]; !ok {
+			http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var families []*dto.MetricFamily
+	success := false
+	if cluster, ok := lookupCluster(target); ok {
+		cluster.RefreshCredentialsIfNeeded()
+		families, success = probeCluster(r.Context(), cluster, names)
+	} else {
+		log.Printf("Probe of unknown target %s", target)
+	}
+
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nutanix_probe_success",
+		Help: "Whether the target was known and its metrics were collected (1) or not (0).",
+	})
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nutanix_probe_duration_seconds",
+		Help: "Time the probe took in seconds.",
+	})
+	if success {
+		probeSuccess.Set(1)
+	}
+	probeDuration.Set(time.Since(start).Seconds())
+	probeRegistry := prometheus.NewRegistry()
+	probeRegistry.MustRegister(probeSuccess, probeDuration)
+
+	gatherer := prometheus.Gatherers{
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil }),
+		probeRegistry,
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeCluster returns the metrics of the selected collectors of the cluster
+// Probes share a cached collection of the cluster or one in progress with scrapes, otherwise only the selected
+// collectors are collected. Either way probes are bounded by the same concurrency limits as scrapes.
+// Collectors that are not configured for the cluster are skipped, and clusters in a blackout window or
+// in quarantine return only their status metrics.
+func probeCluster(ctx context.Context, cluster *nutanix.Cluster, names []string) ([]*dto.MetricFamily, bool) {
+	gathered, err := gatherSelected(ctx, cluster, names)
+	if err != nil {
+		log.Printf("Probe of cluster %s failed: %v", cluster.Name, err)
+	}
+	if gathered == nil {
+		return nil, false
+	}
+	families, selectErr := gathered.selected(names)
+	if selectErr != nil {
+		log.Printf("Probe of cluster %s failed: %v", cluster.Name, selectErr)
+	}
+	return families, err == nil && selectErr == nil && gathered.reached
+}
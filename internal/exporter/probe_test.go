@@ -0,0 +1,114 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// probe requests /probe with the given query and returns the status code and body
+func probe(t *testing.T, query string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	probeHandler(rec, httptest.NewRequest(http.MethodGet, "/probe?"+query, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestProbeCollectorSelection(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		wantCode    int
+		wantSuccess bool
+		wantMetrics []string // Test metrics expected in the response, all others must be missing
+	}{
+		{"all collectors", "target=probe-a", http.StatusOK, true, []string{"test_host", "test_vm"}},
+		{"by uuid", "target=probe-a-uuid", http.StatusOK, true, []string{"test_host", "test_vm"}},
+		{"one collector", "target=probe-a&collect[]=host", http.StatusOK, true, []string{"test_host"}},
+		{"several collectors", "target=probe-a&collect[]=host&collect[]=vm", http.StatusOK, true, []string{"test_host", "test_vm"}},
+		// Nothing of the cluster is collected, so the cluster is not reached
+		{"collector not configured for the cluster", "target=probe-a&collect[]=disk", http.StatusOK, false, nil},
+		{"unknown target", "target=probe-b", http.StatusOK, false, nil},
+		{"unknown collector", "target=probe-a&collect[]=unknown", http.StatusBadRequest, false, nil},
+		{"no target", "collect[]=host", http.StatusBadRequest, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestSettings(t, DefaultConfig())
+			cluster, collectors := newTestCluster(t, "probe-a", "host", "vm")
+			setTestClusters(t, cluster)
+
+			code, body := probe(t, tt.query)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", code, tt.wantCode)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			wantSuccess := "nutanix_probe_success 0"
+			if tt.wantSuccess {
+				wantSuccess = "nutanix_probe_success 1"
+			}
+			if !strings.Contains(body, wantSuccess) {
+				t.Errorf("response is missing %q:\n%s", wantSuccess, body)
+			}
+			for name, collector := range collectors {
+				metric := "test_" + name
+				want := slices.Contains(tt.wantMetrics, metric)
+				if got := strings.Contains(body, metric+" 1"); got != want {
+					t.Errorf("%s in response = %v, want %v", metric, got, want)
+				}
+				// Without a cached collection, only the selected collectors call the Nutanix APIs
+				if called := collector.calls.Load() > 0; called != want {
+					t.Errorf("%s collector called = %v, want %v", name, called, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeSharesCachedCollection(t *testing.T) {
+	config := DefaultConfig()
+	config.MetricsCacheTTL = time.Hour
+	setTestSettings(t, config)
+	cluster, collectors := newTestCluster(t, "probe-cached", "host", "vm")
+	setTestClusters(t, cluster)
+
+	if _, _, err := gatherCluster(context.Background(), cluster); err != nil {
+		t.Fatalf("gathering the cluster: %v", err)
+	}
+
+	code, body := probe(t, "target=probe-cached&collect[]=host")
+	if code != http.StatusOK || !strings.Contains(body, "nutanix_probe_success 1") {
+		t.Fatalf("probe failed with status %d:\n%s", code, body)
+	}
+	if !strings.Contains(body, "test_host 1") || strings.Contains(body, "test_vm") {
+		t.Errorf("probe did not return only the selected collector:\n%s", body)
+	}
+	for name, collector := range collectors {
+		if calls := collector.calls.Load(); calls != 1 {
+			t.Errorf("%s collector called %d times, want once for the cached collection", name, calls)
+		}
+	}
+}
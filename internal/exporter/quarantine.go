@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const DefaultQuarantineProbeInterval = 5 * time.Minute
+
+var (
+	// State is keyed by cluster ID so it survives cluster list refreshes
+	quarantineMu    sync.Mutex
+	quarantineState = make(map[string]*clusterQuarantine)
+
+	quarantinedDesc = prometheus.NewDesc(
+		"nutanix_exporter_cluster_quarantined",
+		"Whether collection of the cluster is suspended after repeated failures (1) or not (0).",
+		[]string{"cluster_name", "cluster_uuid"}, nil,
+	)
+)
+
+// clusterQuarantine tracks the collection failures of one cluster
+type clusterQuarantine struct {
+	failures    int       // Consecutive failed collections
+	quarantined bool      // Whether collection is suspended
+	lastProbe   time.Time // Last collection attempt while quarantined
+}
+
+// quarantineFor returns the quarantine state of a cluster, creating it if needed
+// quarantineMu must be held
+func quarantineFor(cluster *nutanix.Cluster) *clusterQuarantine {
+	q, ok := quarantineState[cluster.ID()]
+	if !ok {
+		q = &clusterQuarantine{}
+		quarantineState[cluster.ID()] = q
+	}
+	return q
+}
+
+// quarantineAllows reports whether the cluster may be collected now
+// A quarantined cluster is let through once per probe interval
+func quarantineAllows(cluster *nutanix.Cluster) bool {
+	s := current()
+	if s.QuarantineThreshold <= 0 {
+		return true
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	q := quarantineFor(cluster)
+	if !q.quarantined {
+		return true
+	}
+	if time.Since(q.lastProbe) < s.QuarantineProbeInterval {
+		return false
+	}
+	q.lastProbe = time.Now()
+	return true
+}
+
+// quarantined reports whether collection of the cluster is suspended, without taking a probe
+func quarantined(cluster *nutanix.Cluster) bool {
+	if current().QuarantineThreshold <= 0 {
+		return false
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	return quarantineFor(cluster).quarantined
+}
+
+// recordCollection updates the quarantine state of a cluster with the outcome of a collection
+func recordCollection(cluster *nutanix.Cluster, ok bool) {
+	s := current()
+	if s.QuarantineThreshold <= 0 {
+		return
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	q := quarantineFor(cluster)
+	if ok {
+		if q.quarantined {
+			log.Printf("Cluster %s recovered, releasing it from quarantine", cluster.Name)
+		}
+		q.failures = 0
+		q.quarantined = false
+		return
+	}
+
+	q.failures++
+	if !q.quarantined && q.failures >= s.QuarantineThreshold {
+		log.Printf("Cluster %s failed %d consecutive collections, quarantining it and probing every %s",
+			cluster.Name, q.failures, s.QuarantineProbeInterval)
+		q.quarantined = true
+		q.lastProbe = time.Now()
+	}
+}
+
+// collectCluster gathers the named collectors of the cluster, or all if no names are given, and records
+// whether the collection reached the cluster. A collection fails if none of its API calls succeeded
+func collectCluster(cluster *nutanix.Cluster, names []string) (*clusterGather, error) {
+	start := time.Now()
+	gathered, err := gatherCollectors(cluster, names)
+	gathered.reached = err == nil && !cluster.LastReachable().Before(start)
+	recordCollection(cluster, gathered.reached)
+	recordClusterUp(cluster, gathered.reached)
+	return gathered, err
+}
+
+// quarantineCollector exposes whether a cluster is quarantined
+type quarantineCollector struct {
+	cluster *nutanix.Cluster
+}
+
+// Describe method required by prometheus.Collector interface
+func (c *quarantineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- quarantinedDesc
+}
+
+// Collect method required by prometheus.Collector interface
+func (c *quarantineCollector) Collect(ch chan<- prometheus.Metric) {
+	quarantineMu.Lock()
+	quarantined := quarantineFor(c.cluster).quarantined
+	quarantineMu.Unlock()
+
+	value := 0.0
+	if quarantined {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(quarantinedDesc, prometheus.GaugeValue, value, c.cluster.Name, c.cluster.UUID)
+}
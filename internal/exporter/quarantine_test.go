@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+// newQuarantineCluster returns a cluster whose quarantine state is removed after the test
+func newQuarantineCluster(t *testing.T, name string) *nutanix.Cluster {
+	t.Helper()
+	cluster := &nutanix.Cluster{Name: name, UUID: name + "-uuid"}
+	t.Cleanup(func() {
+		quarantineMu.Lock()
+		delete(quarantineState, cluster.ID())
+		quarantineMu.Unlock()
+	})
+	return cluster
+}
+
+func TestQuarantineTransitions(t *testing.T) {
+	tests := []struct {
+		name            string
+		threshold       int
+		outcomes        []bool // Outcomes of the collections in order
+		wantQuarantined bool
+		wantAllowed     bool
+	}{
+		{"no collections", 3, nil, false, true},
+		{"below the threshold", 3, []bool{false, false}, false, true},
+		{"at the threshold", 3, []bool{false, false, false}, true, false},
+		{"success resets the failures", 3, []bool{false, false, true, false, false}, false, true},
+		{"failed probe stays quarantined", 3, []bool{false, false, false, false}, true, false},
+		{"successful probe releases", 3, []bool{false, false, false, true}, false, true},
+		{"failure after release", 3, []bool{false, false, false, true, false}, false, true},
+		{"disabled", 0, []bool{false, false, false, false}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.QuarantineThreshold = tt.threshold
+			config.QuarantineProbeInterval = time.Hour
+			setTestSettings(t, config)
+			cluster := newQuarantineCluster(t, "quarantine")
+
+			for _, ok := range tt.outcomes {
+				recordCollection(cluster, ok)
+			}
+
+			if got := quarantined(cluster); got != tt.wantQuarantined {
+				t.Errorf("quarantined = %v, want %v", got, tt.wantQuarantined)
+			}
+			if got := quarantineAllows(cluster); got != tt.wantAllowed {
+				t.Errorf("quarantineAllows = %v, want %v", got, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestQuarantineProbeInterval(t *testing.T) {
+	config := DefaultConfig()
+	config.QuarantineThreshold = 1
+	config.QuarantineProbeInterval = time.Hour
+	setTestSettings(t, config)
+	cluster := newQuarantineCluster(t, "quarantine-probe")
+
+	recordCollection(cluster, false)
+	if quarantineAllows(cluster) {
+		t.Fatalf("quarantined cluster allowed before the probe interval elapsed")
+	}
+
+	quarantineMu.Lock()
+	quarantineFor(cluster).lastProbe = time.Now().Add(-time.Hour)
+	quarantineMu.Unlock()
+
+	if !quarantineAllows(cluster) {
+		t.Fatalf("quarantined cluster not probed after the probe interval elapsed")
+	}
+	if quarantineAllows(cluster) {
+		t.Errorf("quarantined cluster probed twice in one probe interval")
+	}
+	if !quarantined(cluster) {
+		t.Errorf("cluster released from quarantine by a probe without a collection")
+	}
+}
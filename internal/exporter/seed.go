@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSeedRetryInterval is how often Prism Central is retried when running from the seed file
+// and no cluster refresh interval is configured
+const DefaultSeedRetryInterval = 5 * time.Minute
+
+// LoadSeed reads the cluster list from the seed file
+func LoadSeed(path string) ([]ClusterTarget, error) {
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []ClusterTarget
+	if err := yaml.Unmarshal(yamlFile, &targets); err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if target.Name == "" || target.UUID == "" || target.URL == "" {
+			return nil, fmt.Errorf("cluster without a name, uuid or url in %s", path)
+		}
+	}
+	return targets, nil
+}
+
+// SaveSeed writes the cluster list to the seed file
+// The file is replaced atomically so a crash mid-write never leaves a truncated list behind
+func SaveSeed(path string, targets []ClusterTarget) error {
+	data, err := yaml.Marshal(targets)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+// pcDiscovery lists the clusters registered in Prism Central, nil when Prism Central is not used
+var pcDiscovery func() ([]ClusterTarget, error)
+
+// SelfTestCheck is the result of one connectivity check
+type SelfTestCheck struct {
+	Name     string  `json:"name"`
+	OK       bool    `json:"ok"`
+	Detail   string  `json:"detail,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// SelfTestReport is the JSON report served by /-/selftest
+type SelfTestReport struct {
+	OK           bool            `json:"ok"`
+	Vault        *SelfTestCheck  `json:"vault,omitempty"`
+	PrismCentral *SelfTestCheck  `json:"prism_central,omitempty"`
+	Clusters     []SelfTestCheck `json:"clusters"`
+}
+
+// runCheck times the check and records its outcome
+func runCheck(name string, check func() (string, error)) SelfTestCheck {
+	start := time.Now()
+	detail, err := check()
+	result := SelfTestCheck{
+		Name:     name,
+		OK:       err == nil,
+		Detail:   detail,
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// selfTestHandler serves /-/selftest, checking Vault, Prism Central discovery and every cluster on demand
+// Responds with 503 if any check fails. Requires the admin credentials when they are configured.
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if s := current(); (s.AdminUsername != "" || s.AdminPassword != "") && !authorizeAdmin(w, r) {
+		return
+	}
+
+	report := SelfTestReport{OK: true, Clusters: []SelfTestCheck{}}
+
+	if vaultCheck != nil {
+		check := runCheck("vault", func() (string, error) {
+			if err := vaultCheck(); err != nil {
+				return "", err
+			}
+			markVaultReachable()
+			return "", nil
+		})
+		report.Vault = &check
+		report.OK = report.OK && check.OK
+	}
+
+	var discovered []ClusterTarget
+	if pcDiscovery != nil {
+		check := runCheck("prism_central", func() (string, error) {
+			targets, err := pcDiscovery()
+			if err != nil {
+				return "", err
+			}
+			markPCReachable()
+			discovered = targets
+			return fmt.Sprintf("discovered %d clusters", len(targets)), nil
+		})
+		report.PrismCentral = &check
+		report.OK = report.OK && check.OK
+	}
+
+	clustersMu.RLock()
+	clusters := make([]*nutanix.Cluster, 0, len(ClustersMap))
+	for _, cluster := range ClustersMap {
+		clusters = append(clusters, cluster)
+	}
+	expected := append(append([]ClusterTarget{}, staticTargets...), discovered...)
+	clustersMu.RUnlock()
+
+	// Clusters that are configured or discovered but not served failed to initialize, e.g. their credentials
+	// could not be loaded, or were discovered after the last cluster refresh
+	for _, target := range missingClusters(expected, clusters) {
+		report.Clusters = append(report.Clusters, SelfTestCheck{
+			Name:  target.Name,
+			Error: "cluster is not monitored, it failed to initialize or was discovered after the last refresh",
+		})
+		report.OK = false
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster *nutanix.Cluster) {
+			defer wg.Done()
+			cluster.RefreshCredentialsIfNeeded()
+			check := runCheck(cluster.Name, func() (string, error) {
+				return "", pingCluster(cluster)
+			})
+			mu.Lock()
+			report.Clusters = append(report.Clusters, check)
+			report.OK = report.OK && check.OK
+			mu.Unlock()
+		}(cluster)
+	}
+	wg.Wait()
+
+	sort.Slice(report.Clusters, func(i, j int) bool { return report.Clusters[i].Name < report.Clusters[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error encoding self-test report: %v", err)
+	}
+}
+
+// missingClusters returns the targets without a served cluster of the same name or UUID
+func missingClusters(targets []ClusterTarget, clusters []*nutanix.Cluster) []ClusterTarget {
+	served := make(map[string]bool, 2*len(clusters))
+	for _, cluster := range clusters {
+		served[cluster.Name] = true
+		served[cluster.ID()] = true
+	}
+
+	var missing []ClusterTarget
+	for _, target := range targets {
+		if served[target.Name] || (target.UUID != "" && served[target.UUID]) {
+			continue
+		}
+		served[target.Name] = true // A cluster that is both static and discovered is reported once
+		missing = append(missing, target)
+	}
+	return missing
+}
+
+// pingCluster makes a lightweight authenticated call to a Prism Element cluster
+func pingCluster(cluster *nutanix.Cluster) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.Timeout)
+	defer cancel()
+
+	resp, err := cluster.API.MakeRequest(ctx, "GET", "/v2.0/cluster/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("authentication failed: %s", resp.Status)
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Settings holds the configuration that can change on reload
+// A snapshot is never modified once stored, a reload stores a new one. Readers call current() once
+// and use that snapshot, so they see either the old or the new configuration, never a mix.
+type Settings struct {
+	Blackouts []*Blackout // Collection blackout windows, empty disables blackouts
+
+	AdminUsername     string        // Credentials for the administrative endpoints
+	AdminPassword     string        // The administrative endpoints are disabled when both are empty
+	DrainDelay        time.Duration // Time between marking the instance not ready and closing the listener
+	DrainTimeout      time.Duration
+	ReadinessWindow   time.Duration // How recently Vault and Prism Central must have been reached
+	LivenessThreshold time.Duration // How long a background loop iteration may run before it counts as stuck
+
+	ClusterPrefix string // Only clusters with this name prefix are discovered
+	SeedFile      string // Cluster list used when Prism Central is unreachable at startup, empty disables the fallback
+
+	Credentials       CredentialsConfig // Default credential provider of all clusters
+	EnabledCollectors []string          // Collectors of clusters without their own selection
+	VMDiskMetrics     bool              // Whether to collect per virtual disk metrics, off by default due to cardinality
+	CacheTTL          time.Duration     // How long gathered cluster metrics are served from memory, 0 disables caching
+
+	QuarantineThreshold     int           // Consecutive failed collections before a cluster is quarantined, 0 disables quarantine
+	QuarantineProbeInterval time.Duration // How often a quarantined cluster is collected to check if it recovered
+}
+
+var settings atomic.Pointer[Settings]
+
+func init() {
+	settings.Store(newSettings(DefaultConfig(), nil))
+}
+
+// newSettings returns the reloadable settings of the configuration
+func newSettings(config *Config, blackouts []*Blackout) *Settings {
+	s := &Settings{
+		Blackouts:               blackouts,
+		AdminUsername:           config.AdminUsername,
+		AdminPassword:           config.AdminPassword,
+		DrainDelay:              config.DrainDelay,
+		DrainTimeout:            config.DrainTimeout,
+		ReadinessWindow:         config.ReadinessWindow,
+		LivenessThreshold:       config.LivenessThreshold,
+		Credentials:             config.Credentials,
+		EnabledCollectors:       config.Collectors,
+		VMDiskMetrics:           config.VMDiskMetrics,
+		CacheTTL:                config.MetricsCacheTTL,
+		QuarantineThreshold:     config.QuarantineThreshold,
+		QuarantineProbeInterval: config.QuarantineProbeInterval,
+	}
+	if pc := config.PrismCentral; pc != nil {
+		s.ClusterPrefix = pc.ClusterPrefix
+		s.SeedFile = pc.SeedFile
+	}
+	return s
+}
+
+// current returns the settings of the active configuration
+func current() *Settings {
+	return settings.Load()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	clusterRefreshFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "nutanix_exporter_cluster_refresh_failures_total",
+		Help: "Number of failed cluster list refreshes from Prism Central.",
+	})
+
+	clusterUpDesc = prometheus.NewDesc(
+		"nutanix_exporter_cluster_up",
+		"Whether the last collection of the cluster reached its API (1) or not (0).",
+		[]string{"cluster_name", "cluster_uuid"}, nil,
+	)
+	vaultTokenTTLDesc = prometheus.NewDesc(
+		"nutanix_exporter_vault_token_ttl_seconds",
+		"Remaining lifetime of the Vault token in seconds.",
+		nil, nil,
+	)
+
+	// Outcome of the last collection of each cluster, keyed by cluster ID so it survives cluster list refreshes
+	clusterUpMu sync.Mutex
+	clusterUp   = make(map[string]bool)
+)
+
+// recordClusterUp records whether the last collection of the cluster reached its API
+func recordClusterUp(cluster *nutanix.Cluster, up bool) {
+	clusterUpMu.Lock()
+	defer clusterUpMu.Unlock()
+	clusterUp[cluster.ID()] = up
+}
+
+// forgetClusters deletes the series of the clusters that are no longer served
+// A renamed cluster loses the series of its old name. A removed cluster also loses its up state and the
+// counters kept by ID, i.e. its collector panics and stuck collections.
+func forgetClusters(previous, served map[string]*nutanix.Cluster) {
+	clusterUpMu.Lock()
+	defer clusterUpMu.Unlock()
+	for id, cluster := range previous {
+		current, ok := served[id]
+		if ok && current.Name == cluster.Name {
+			continue
+		}
+		if !ok {
+			delete(clusterUp, id)
+			prom.Stats.Forget(id)
+		}
+		labels := prometheus.Labels{"cluster_name": cluster.Name, "cluster_uuid": cluster.UUID}
+		prom.ScrapeDuration.DeletePartialMatch(labels)
+		nutanix.APIRequests.DeletePartialMatch(labels)
+	}
+}
+
+// registerTelemetry serves the exporter's own metrics on /metrics
+// They are labelled with every cluster, so they are restricted to the admin while tenants are configured
+func registerTelemetry() {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		nutanix.APIRequests,
+		prom.ScrapeDuration,
+		clusterRefreshFailures,
+		&telemetryCollector{},
+	)
+	http.HandleFunc("/metrics", allClusters(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP))
+}
+
+// telemetryCollector exposes the exporter's own metrics that are computed at collect time
+type telemetryCollector struct{}
+
+// Describe method required by prometheus.Collector interface
+func (c *telemetryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clusterUpDesc
+	ch <- vaultTokenTTLDesc
+}
+
+// Collect sends the up state of every collected cluster and the remaining lifetime of the Vault token
+func (c *telemetryCollector) Collect(ch chan<- prometheus.Metric) {
+	clustersMu.RLock()
+	clusters := make([]*nutanix.Cluster, 0, len(ClustersMap))
+	for _, cluster := range ClustersMap {
+		clusters = append(clusters, cluster)
+	}
+	clustersMu.RUnlock()
+
+	clusterUpMu.Lock()
+	for _, cluster := range clusters {
+		up, ok := clusterUp[cluster.ID()]
+		if !ok {
+			continue // Not collected yet
+		}
+		value := 0.0
+		if up {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(clusterUpDesc, prometheus.GaugeValue, value, cluster.Name, cluster.UUID)
+	}
+	clusterUpMu.Unlock()
+
+	if VaultClient != nil {
+		ttl, err := VaultClient.TokenTTL()
+		if err != nil {
+			log.Printf("Failed to look up Vault token: %v", err)
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(vaultTokenTTLDesc, prometheus.GaugeValue, ttl.Seconds())
+	}
+}
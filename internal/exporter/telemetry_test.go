@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+	"github.com/ingka-group/nutanix-exporter/internal/prom"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestForgetClusters(t *testing.T) {
+	kept := &nutanix.Cluster{Name: "kept", UUID: "kept-uuid"}
+	renamed := &nutanix.Cluster{Name: "old-name", UUID: "renamed-uuid"}
+	removed := &nutanix.Cluster{Name: "removed", UUID: "removed-uuid"}
+	previous := map[string]*nutanix.Cluster{kept.ID(): kept, renamed.ID(): renamed, removed.ID(): removed}
+	served := map[string]*nutanix.Cluster{
+		kept.ID():    kept,
+		renamed.ID(): {Name: "new-name", UUID: renamed.UUID},
+	}
+
+	prom.ScrapeDuration.Reset()
+	nutanix.APIRequests.Reset()
+	for _, cluster := range previous {
+		prom.ScrapeDuration.WithLabelValues(cluster.Name, cluster.UUID, "host").Set(1)
+		nutanix.APIRequests.WithLabelValues(cluster.Name, cluster.UUID, "/v2.0/hosts/", "200").Inc()
+		recordClusterUp(cluster, true)
+		prom.Stats.Inc(prom.CollectorPanicsDesc, cluster, "host")
+	}
+
+	forgetClusters(previous, served)
+
+	if n := testutil.CollectAndCount(prom.ScrapeDuration); n != 1 {
+		t.Errorf("%d scrape duration series left, want only the kept cluster's", n)
+	}
+	if n := testutil.CollectAndCount(nutanix.APIRequests); n != 1 {
+		t.Errorf("%d API request series left, want only the kept cluster's", n)
+	}
+
+	clusterUpMu.Lock()
+	_, keptUp := clusterUp[kept.ID()]
+	_, renamedUp := clusterUp[renamed.ID()]
+	_, removedUp := clusterUp[removed.ID()]
+	clusterUpMu.Unlock()
+	if !keptUp || !renamedUp || removedUp {
+		t.Errorf("up state kept = %v, renamed = %v, removed = %v, want true, true, false", keptUp, renamedUp, removedUp)
+	}
+
+	for _, tt := range []struct {
+		cluster *nutanix.Cluster
+		want    int
+	}{{kept, 1}, {served[renamed.ID()], 1}, {removed, 0}} {
+		if n := testutil.CollectAndCount(prom.NewStatsCollector(tt.cluster)); n != tt.want {
+			t.Errorf("cluster %s has %d counters, want %d", tt.cluster.Name, n, tt.want)
+		}
+	}
+}
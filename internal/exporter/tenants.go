@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tenantsEnabled is set at startup if tenants are configured
+var tenantsEnabled bool
+
+// Tenant is a named group of clusters that can only be scraped with the tenant's own credentials
+type Tenant struct {
+	Name     string   `yaml:"name"`
+	Clusters []string `yaml:"clusters"` // Cluster names or glob patterns, e.g. "team-a-*"
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// LoadTenants reads the tenant definitions from the given YAML file
+func LoadTenants(configPath string) (map[string]*Tenant, error) {
+	yamlFile, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenantList []*Tenant
+	if err := yaml.Unmarshal(yamlFile, &tenantList); err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]*Tenant)
+	for _, t := range tenantList {
+		if t.Name == "" {
+			return nil, fmt.Errorf("tenant without a name in %s", configPath)
+		}
+		if _, exists := tenants[t.Name]; exists {
+			return nil, fmt.Errorf("duplicate tenant %s in %s", t.Name, configPath)
+		}
+		if t.Username == "" || t.Password == "" {
+			return nil, fmt.Errorf("tenant %s without a username and password in %s", t.Name, configPath)
+		}
+		tenants[t.Name] = t
+		log.Printf("Loaded tenant %s with %d cluster pattern(s)", t.Name, len(t.Clusters))
+	}
+
+	return tenants, nil
+}
+
+// HasCluster reports whether the named cluster belongs to the tenant
+func (t *Tenant) HasCluster(name string) bool {
+	return matchesAny(t.Clusters, name)
+}
+
+// Authorized checks the request's basic auth credentials against the tenant's credentials
+func (t *Tenant) Authorized(r *http.Request) bool {
+	if t.Username == "" || t.Password == "" {
+		return false
+	}
+	return checkBasicAuth(r, t.Username, t.Password)
+}
+
+// allClusters guards an endpoint that serves the metrics or the list of any cluster
+// While tenants are configured, such endpoints require the admin credentials and are disabled
+// without them. Must be called after tenantsEnabled is set.
+func allClusters(handler http.HandlerFunc) http.HandlerFunc {
+	if !tenantsEnabled {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r) {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkBasicAuth compares the request's basic auth credentials in constant time
+func checkBasicAuth(r *http.Request, username, password string) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOk := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+	return userOk && passOk
+}
+
+// matchesAny reports whether name matches any of the given names or glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantMetricsHandler serves /tenants/<tenant>/metrics/<cluster> for clusters owned by the tenant
+func tenantMetricsHandler(tenants map[string]*Tenant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Expected path: /tenants/<tenant>/metrics/<cluster>
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/tenants/"), "/", 3)
+		if len(parts) != 3 || parts[1] != "metrics" || parts[2] == "" {
+			http.NotFound(w, r)
+			return
+		}
+		tenantName, clusterName := parts[0], parts[2]
+
+		tenant, ok := tenants[tenantName]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !tenant.Authorized(r) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, tenant.Name))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Clusters outside the tenant are reported as missing rather than forbidden
+		// so that tenants cannot enumerate each other's clusters
+		cluster, ok := lookupCluster(clusterName)
+		if !ok || !tenant.HasCluster(cluster.Name) {
+			http.NotFound(w, r)
+			return
+		}
+		createClusterMetricsHandler(cluster)(w, r)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+// setTestClusters serves the given clusters for the duration of the test
+func setTestClusters(t *testing.T, clusters ...*nutanix.Cluster) {
+	t.Helper()
+	served := make(map[string]*nutanix.Cluster, len(clusters))
+	for _, cluster := range clusters {
+		served[cluster.ID()] = cluster
+	}
+
+	clustersMu.Lock()
+	previous := ClustersMap
+	ClustersMap = served
+	clustersMu.Unlock()
+	t.Cleanup(func() {
+		clustersMu.Lock()
+		ClustersMap = previous
+		clustersMu.Unlock()
+	})
+}
+
+// setTestSettings applies the settings of the given configuration for the duration of the test
+func setTestSettings(t *testing.T, config *Config) {
+	t.Helper()
+	previous := current()
+	settings.Store(newSettings(config, nil))
+	t.Cleanup(func() { settings.Store(previous) })
+}
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		cluster  string
+		want     bool
+	}{
+		{"exact name", []string{"cluster-a"}, "cluster-a", true},
+		{"other name", []string{"cluster-a"}, "cluster-b", false},
+		{"glob", []string{"team-a-*"}, "team-a-1", true},
+		{"glob of another team", []string{"team-a-*"}, "team-b-1", false},
+		{"any of several patterns", []string{"cluster-a", "team-b-?"}, "team-b-1", true},
+		{"invalid pattern matches only itself", []string{"team-["}, "team-[", true},
+		{"invalid pattern", []string{"team-["}, "team-a", false},
+		{"no patterns", nil, "cluster-a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesAny(tt.patterns, tt.cluster); got != tt.want {
+				t.Errorf("matchesAny(%q, %q) = %v, want %v", tt.patterns, tt.cluster, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTenantMetricsHandler(t *testing.T) {
+	own, _ := newTestCluster(t, "team-a-1", "host")
+	other, _ := newTestCluster(t, "team-b-1", "host")
+	setTestClusters(t, own, other)
+
+	handler := tenantMetricsHandler(map[string]*Tenant{
+		"team-a": {Name: "team-a", Clusters: []string{"team-a-*"}, Username: "a", Password: "secret-a"},
+		"team-b": {Name: "team-b", Clusters: []string{"team-b-*"}, Username: "b", Password: "secret-b"},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		username string
+		password string
+		want     int
+	}{
+		{"own cluster", "/tenants/team-a/metrics/team-a-1", "a", "secret-a", http.StatusOK},
+		{"own cluster by uuid", "/tenants/team-a/metrics/team-a-1-uuid", "a", "secret-a", http.StatusOK},
+		// Clusters of other tenants must look like missing clusters, not forbidden ones
+		{"cluster of another tenant", "/tenants/team-a/metrics/team-b-1", "a", "secret-a", http.StatusNotFound},
+		{"cluster of another tenant by uuid", "/tenants/team-a/metrics/team-b-1-uuid", "a", "secret-a", http.StatusNotFound},
+		{"unknown cluster", "/tenants/team-a/metrics/team-a-2", "a", "secret-a", http.StatusNotFound},
+		{"credentials of another tenant", "/tenants/team-a/metrics/team-a-1", "b", "secret-b", http.StatusUnauthorized},
+		{"wrong password", "/tenants/team-a/metrics/team-a-1", "a", "secret-b", http.StatusUnauthorized},
+		{"no credentials", "/tenants/team-a/metrics/team-a-1", "", "", http.StatusUnauthorized},
+		{"unknown tenant", "/tenants/team-c/metrics/team-a-1", "a", "secret-a", http.StatusNotFound},
+		{"no cluster", "/tenants/team-a/metrics/", "a", "secret-a", http.StatusNotFound},
+		{"not a metrics path", "/tenants/team-a/other/team-a-1", "a", "secret-a", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), "test_host") {
+				t.Errorf("response is missing the cluster's metrics:\n%s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAllClusters(t *testing.T) {
+	tests := []struct {
+		name          string
+		tenants       bool
+		adminUsername string
+		adminPassword string
+		username      string
+		password      string
+		want          int
+	}{
+		{"tenants disabled", false, "", "", "", "", http.StatusOK},
+		{"tenants without admin credentials", true, "", "", "admin", "secret", http.StatusForbidden},
+		{"no credentials", true, "admin", "secret", "", "", http.StatusUnauthorized},
+		{"wrong credentials", true, "admin", "secret", "admin", "wrong", http.StatusUnauthorized},
+		{"admin credentials", true, "admin", "secret", "admin", "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.AdminUsername, config.AdminPassword = tt.adminUsername, tt.adminPassword
+			setTestSettings(t, config)
+
+			previous := tenantsEnabled
+			tenantsEnabled = tt.tenants
+			t.Cleanup(func() { tenantsEnabled = previous })
+
+			handler := allClusters(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/metrics/cluster-a", nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exporter
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WebConfig configures TLS and the HTTP server, following the web config file format of the
+// Prometheus exporter-toolkit. Basic auth users are not supported.
+type WebConfig struct {
+	TLSServerConfig  *TLSServerConfig `yaml:"tls_server_config"` // Optional, serves plain HTTP if not set
+	HTTPServerConfig HTTPServerConfig `yaml:"http_server_config"`
+}
+
+// TLSServerConfig holds the server certificate and the optional client certificate verification
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"` // Defaults to NoClientCert
+	ClientCAFile   string `yaml:"client_ca_file"`   // Required to verify client certificates
+	MinVersion     string `yaml:"min_version"`      // TLS10 to TLS13, defaults to TLS12
+	MaxVersion     string `yaml:"max_version"`      // Defaults to TLS13
+}
+
+// HTTPServerConfig holds the HTTP server settings
+type HTTPServerConfig struct {
+	HTTP2   *bool             `yaml:"http2"`   // Defaults to true, only used with TLS
+	Headers map[string]string `yaml:"headers"` // Added to every response
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// LoadWebConfig reads the web config from the given YAML file
+// Unknown keys are rejected, so unsupported exporter-toolkit settings don't go unnoticed
+func LoadWebConfig(configPath string) (*WebConfig, error) {
+	yamlFile, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config WebConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", configPath, err)
+	}
+	return &config, nil
+}
+
+// tlsConfig builds the TLS configuration of the server
+// The certificate is read on every handshake, so renewed certificates are used without a restart
+func (c *TLSServerConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("tls_server_config requires a cert_file and a key_file")
+	}
+	if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		MaxVersion: tls.VersionTLS13,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the server certificate: %v", err)
+			}
+			return &cert, nil
+		},
+	}
+
+	var ok bool
+	if c.MinVersion != "" {
+		if config.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+			return nil, fmt.Errorf("unknown min_version %s", c.MinVersion)
+		}
+	}
+	if c.MaxVersion != "" {
+		if config.MaxVersion, ok = tlsVersions[c.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown max_version %s", c.MaxVersion)
+		}
+	}
+	if config.ClientAuth, ok = clientAuthTypes[c.ClientAuthType]; !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %s", c.ClientAuthType)
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client CA: %v", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+	} else if config.ClientAuth == tls.VerifyClientCertIfGiven || config.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s requires a client_ca_file", c.ClientAuthType)
+	}
+
+	return config, nil
+}
+
+// withHeaders adds the configured headers to every response
+func withHeaders(handler http.Handler, headers map[string]string) http.Handler {
+	if len(headers) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			w.Header().Set(name, value)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultDNSTimeout = 5 * time.Second
+
+// dialer opens all outbound connections to Nutanix clusters
+var dialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// SetResolver makes connections to Nutanix clusters resolve names through the given DNS servers
+// instead of the host resolver. Servers without a port use port 53, and each query is bounded by timeout.
+// Only affects HTTP clients created afterwards, so it should be called before any cluster is set up.
+func SetResolver(servers []string, timeout time.Duration) {
+	if len(servers) == 0 {
+		dialer.Resolver = nil
+		return
+	}
+	if timeout <= 0 {
+		timeout = DefaultDNSTimeout
+	}
+
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		addrs = append(addrs, server)
+	}
+	log.Printf("Resolving Nutanix cluster addresses through %v", addrs)
+
+	pool := &dnsServers{addrs: addrs, timeout: timeout}
+	dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial:     pool.dial,
+	}
+}
+
+// dnsServers fails over between DNS servers
+// Dialing UDP doesn't contact the server, so a dead server is only noticed when a query to it times out.
+// Queries go to the current server until one times out, then the next server becomes current. The Go
+// resolver retries a timed out query, so the retry already goes to the next server.
+type dnsServers struct {
+	addrs   []string
+	timeout time.Duration
+	current atomic.Uint32 // Index of the server queries are sent to
+}
+
+// dial connects to the current server, or the following ones if that fails
+// The address chosen by the Go resolver is ignored in favour of the configured servers
+func (s *dnsServers) dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	d := net.Dialer{Timeout: s.timeout}
+	start := s.current.Load()
+	var lastErr error
+	for i := range uint32(len(s.addrs)) {
+		index := (start + i) % uint32(len(s.addrs))
+		conn, err := d.DialContext(ctx, network, s.addrs[index])
+		if err != nil {
+			lastErr = err
+			s.failed(index)
+			continue
+		}
+		dc := &dnsConn{Conn: conn, servers: s, index: index, deadline: time.Now().Add(s.timeout)}
+		if err := dc.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+		// The resolver frames queries as UDP datagrams only if the connection is a PacketConn
+		if packet, ok := conn.(net.PacketConn); ok {
+			return &dnsPacketConn{dnsConn: dc, packet: packet}, nil
+		}
+		return dc, nil
+	}
+	return nil, fmt.Errorf("no DNS server reachable: %w", lastErr)
+}
+
+// failed moves on from the server at index, unless another query already did
+func (s *dnsServers) failed(index uint32) {
+	next := (index + 1) % uint32(len(s.addrs))
+	if s.current.CompareAndSwap(index, next) && next != index {
+		log.Printf("DNS server %s failed, switching to %s", s.addrs[index], s.addrs[next])
+	}
+}
+
+// dnsConn is a connection to one DNS server that reports timed out queries to its dnsServers
+type dnsConn struct {
+	net.Conn
+	servers  *dnsServers
+	index    uint32
+	deadline time.Time // Bounds the query, the resolver may only shorten it
+}
+
+// dnsPacketConn is a dnsConn over UDP
+type dnsPacketConn struct {
+	*dnsConn
+	packet net.PacketConn
+}
+
+// Read reads a response, a timeout fails over to the next server
+func (c *dnsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.checkTimeout(err)
+	return n, err
+}
+
+// checkTimeout fails over to the next server if the query timed out
+func (c *dnsConn) checkTimeout(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.servers.failed(c.index)
+	}
+}
+
+// ReadFrom reads a response datagram, a timeout fails over to the next server
+func (c *dnsPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.packet.ReadFrom(b)
+	c.checkTimeout(err)
+	return n, addr, err
+}
+
+// WriteTo writes a query datagram
+func (c *dnsPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.packet.WriteTo(b, addr)
+}
+
+// SetDeadline sets the deadline of the query, capped at the configured DNS timeout
+func (c *dnsConn) SetDeadline(t time.Time) error {
+	if t.IsZero() || t.After(c.deadline) {
+		t = c.deadline
+	}
+	return c.Conn.SetDeadline(t)
+}
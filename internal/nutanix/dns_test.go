@@ -0,0 +1,143 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testAddress is the A record served by startDNSServer for every name
+var testAddress = net.IPv4(10, 0, 0, 1).To4()
+
+// startDNSServer starts a UDP DNS server on localhost and returns its address
+// A server that doesn't answer reads the queries and drops them, like a dead server behind a firewall.
+func startDNSServer(t *testing.T, answer bool) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !answer {
+				continue
+			}
+			if resp := dnsResponse(buf[:n]); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// dnsResponse answers an A query with testAddress, other queries without records
+func dnsResponse(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// The question follows the header as a sequence of labels ending with an empty one, then type and class
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end-4:])
+
+	resp := make([]byte, 0, end+16)
+	resp = append(resp, query[0], query[1], 0x81, 0x80) // Query ID, response with recursion available
+	resp = binary.BigEndian.AppendUint16(resp, 1)       // Questions
+	if qtype == 1 {
+		resp = binary.BigEndian.AppendUint16(resp, 1) // Answers
+	} else {
+		resp = binary.BigEndian.AppendUint16(resp, 0)
+	}
+	resp = append(resp, 0, 0, 0, 0) // Authority and additional records
+	resp = append(resp, query[12:end]...)
+	if qtype == 1 {
+		resp = append(resp, 0xc0, 12)                      // Name, pointing to the question
+		resp = append(resp, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4) // Type A, class IN, TTL 60, 4 bytes of data
+		resp = append(resp, testAddress...)
+	}
+	return resp
+}
+
+func TestDNSServersFailover(t *testing.T) {
+	tests := []struct {
+		name        string
+		answers     []bool // Whether each server answers
+		wantErr     bool
+		wantCurrent uint32
+	}{
+		{"first server answers", []bool{true, false}, false, 0},
+		{"fails over from a dead server", []bool{false, true}, false, 1},
+		{"fails over past several dead servers", []bool{false, false, true}, false, 2},
+		{"all servers dead", []bool{false, false}, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addrs []string
+			for _, answer := range tt.answers {
+				addrs = append(addrs, startDNSServer(t, answer))
+			}
+			servers := &dnsServers{addrs: addrs, timeout: 200 * time.Millisecond}
+			resolver := &net.Resolver{PreferGo: true, Dial: servers.dial}
+
+			// Each lookup retries a timed out query once, so several may be needed to pass all dead servers
+			var ips []net.IP
+			var err error
+			for range tt.answers {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				ips, err = resolver.LookupIP(ctx, "ip4", "cluster.example.")
+				cancel()
+				if err == nil {
+					break
+				}
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("lookup succeeded with %v, want an error", ips)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup failed: %v", err)
+			}
+			if !slices.ContainsFunc(ips, func(ip net.IP) bool { return ip.Equal(testAddress) }) {
+				t.Errorf("lookup returned %v, want %s", ips, testAddress)
+			}
+			if current := servers.current.Load(); current != tt.wantCurrent {
+				t.Errorf("current server = %d, want %d", current, tt.wantCurrent)
+			}
+		})
+	}
+}
@@ -21,10 +21,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/auth"
@@ -32,40 +34,95 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var (
+	reloginInterval    atomic.Int64 // See SetReloginInterval
+	maxClusterRequests atomic.Int64 // See SetMaxClusterRequests
+)
+
+// SetReloginInterval sets how long credentials and sessions of a cluster are used before they are
+// discarded and fetched again, 0 disables forced re-login
+func SetReloginInterval(interval time.Duration) {
+	reloginInterval.Store(int64(interval))
+}
+
+// SetMaxClusterRequests bounds the API requests in flight to each cluster, 0 is unlimited
+// Applies to clusters created after it is set
+func SetMaxClusterRequests(n int) {
+	maxClusterRequests.Store(int64(n))
+}
+
 type NutanixClient interface {
-	RefreshCredentials(vaultClient *auth.VaultClient) error
+	SetCredentials(username, password string)
 	CreateRequest(ctx context.Context, reqType, action string, p RequestParams) (*http.Request, error)
 	MakeRequestWithParams(ctx context.Context, reqType, action string, p RequestParams) (*http.Response, error)
 	MakeRequest(ctx context.Context, reqType, action string) (*http.Response, error)
+	Recycle()
+	Abort()
 }
 
 // Cluster represents a Nutanix cluster (Prism Central OR Element)
 type Cluster struct {
 	Name          string
-	URL           string `yaml:"URL"`
+	UUID          string                  // Cluster extId, stable across renames
+	URL           string                  `yaml:"URL"`
+	Credentials   auth.CredentialProvider // Source of the cluster's username and password
+	Timeout       time.Duration           // Timeout of API requests and collections
 	API           NutanixClient
 	Registry      *prometheus.Registry
 	Collectors    []prometheus.Collector
 	RefreshNeeded bool
 	Mutex         sync.Mutex
+	loggedInAt    time.Time     // When the current credentials were fetched
+	lastReachable atomic.Int64  // Unix nanoseconds of the last successful API call
+	requests      chan struct{} // Request slots, nil when unlimited
 }
 
 // PEClient represents the Prism Element API client
 type PEClient struct {
+	Cluster       string // Cluster name and UUID, used to label the API request metrics
+	ClusterUUID   string
 	URL           string
-	Username      string
-	Password      string
 	SkipTLSVerify bool
 	Timeout       time.Duration
+	credentials   atomic.Pointer[credentials] // Replaced as a whole, see SetCredentials
+	httpClient    httpClientCache
 }
 
 // PCClient represents the Prism Central API client
 type PCClient struct {
+	Cluster       string // Cluster name and UUID, used to label the API request metrics
+	ClusterUUID   string
 	URL           string
-	Username      string
-	Password      string
 	SkipTLSVerify bool
 	Timeout       time.Duration
+	credentials   atomic.Pointer[credentials] // Replaced as a whole, see SetCredentials
+	httpClient    httpClientCache
+}
+
+// credentials are never modified, so requests read a consistent username and password while they are replaced
+type credentials struct {
+	username string
+	password string
+}
+
+// httpClientCache lazily creates the HTTP client of an API client and reuses it across requests
+type httpClientCache struct {
+	mu     sync.Mutex
+	client *http.Client
+	conns  *connSet // Connections opened by client
+}
+
+// connSet tracks the open connections of an HTTP client, so they can be closed while in use
+type connSet struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// trackedConn removes itself from its connSet when closed
+type trackedConn struct {
+	net.Conn
+	set *connSet
 }
 
 // RequestParams holds the components for a request (body, header, params)
@@ -76,93 +133,239 @@ type RequestParams struct {
 	Payload interface{}
 }
 
-// NewCluster returns a new Nutanix cluster object, fetching credentials and creating an API client.
-func NewCluster(name, url string, vaultClient *auth.VaultClient, isPC bool, skipTLSVerify bool, timeout time.Duration) *Cluster {
-	var api NutanixClient
-	var username, password string
-	var err error
-
-	if isPC {
-		username, password, err = vaultClient.GetPCCreds(name)
-		if username == "" || password == "" {
+// NewCluster returns a new Nutanix cluster object, fetching credentials from the provider and creating an API client.
+// The UUID is empty for Prism Central
+func NewCluster(name, uuid, url string, credentials auth.CredentialProvider, isPC bool, skipTLSVerify bool, timeout time.Duration) *Cluster {
+	username, password, err := credentials.GetCredentials(name)
+	if username == "" || password == "" {
+		if isPC {
 			log.Printf("Failed to get credentials for Prism Central %s: %v", name, err)
-			return nil
-		}
-		api = NewPCClient(url, username, password, skipTLSVerify, timeout)
-	} else {
-		username, password, err = vaultClient.GetPECreds(name)
-		if username == "" || password == "" {
+		} else {
 			log.Printf("Failed to get credentials for Prism Element %s: %v", name, err)
-			return nil
 		}
-		api = NewPEClient(url, username, password, skipTLSVerify, timeout)
+		return nil
+	}
+
+	var api NutanixClient
+	if isPC {
+		api = NewPCClient(name, uuid, url, username, password, skipTLSVerify, timeout)
+	} else {
+		api = NewPEClient(name, uuid, url, username, password, skipTLSVerify, timeout)
 	}
 
-	return &Cluster{
-		Name:     name,
-		URL:      url,
-		API:      api,
-		Registry: prometheus.NewRegistry(),
+	cluster := &Cluster{
+		Name:        name,
+		UUID:        uuid,
+		URL:         url,
+		Credentials: credentials,
+		Timeout:     timeout,
+		API:         api,
+		Registry:    prometheus.NewRegistry(),
+		loggedInAt:  time.Now(),
+	}
+	if n := maxClusterRequests.Load(); n > 0 {
+		cluster.requests = make(chan struct{}, n)
+	}
+	return cluster
+}
+
+// ID returns the cluster UUID, or the name if the UUID is unknown
+func (c *Cluster) ID() string {
+	if c.UUID != "" {
+		return c.UUID
+	}
+	return c.Name
+}
+
+// MarkReachable records a successful API call to the cluster
+func (c *Cluster) MarkReachable() {
+	c.lastReachable.Store(time.Now().UnixNano())
+}
+
+// LastReachable returns the time of the last successful API call to the cluster
+func (c *Cluster) LastReachable() time.Time {
+	return time.Unix(0, c.lastReachable.Load())
+}
+
+// AcquireRequest waits for a free request slot of the cluster, see MaxClusterRequests
+// The returned function releases the slot
+func (c *Cluster) AcquireRequest(ctx context.Context) (func(), error) {
+	if c.requests == nil {
+		return func() {}, nil
+	}
+	select {
+	case c.requests <- struct{}{}:
+		return func() { <-c.requests }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 // NewPEClient returns a new Prism Element client object
-func NewPEClient(url, username, password string, skipTLSVerify bool, timeout time.Duration) *PEClient {
-	return &PEClient{
+func NewPEClient(cluster, clusterUUID, url, username, password string, skipTLSVerify bool, timeout time.Duration) *PEClient {
+	client := &PEClient{
+		Cluster:       cluster,
+		ClusterUUID:   clusterUUID,
 		URL:           url,
-		Username:      username,
-		Password:      password,
 		SkipTLSVerify: skipTLSVerify,
 		Timeout:       timeout,
 	}
+	client.SetCredentials(username, password)
+	return client
 }
 
 // NewPCClient returns a new Prism Central client object
-func NewPCClient(url, username, password string, skipTLSVerify bool, timeout time.Duration) *PCClient {
-	return &PCClient{
+func NewPCClient(cluster, clusterUUID, url, username, password string, skipTLSVerify bool, timeout time.Duration) *PCClient {
+	client := &PCClient{
+		Cluster:       cluster,
+		ClusterUUID:   clusterUUID,
 		URL:           url,
-		Username:      username,
-		Password:      password,
 		SkipTLSVerify: skipTLSVerify,
 		Timeout:       timeout,
 	}
+	client.SetCredentials(username, password)
+	return client
+}
+
+// get returns the cached HTTP client, creating it if needed
+func (h *httpClientCache) get(skipTLSVerify bool, timeout time.Duration) *http.Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client == nil {
+		h.conns = &connSet{conns: make(map[net.Conn]struct{})}
+		h.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext:     h.conns.dial,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSVerify},
+			},
+			Timeout: timeout,
+		}
+	}
+	return h.client
+}
+
+// recycle closes the idle connections of the cached HTTP client and discards it
+func (h *httpClientCache) recycle() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client != nil {
+		h.client.CloseIdleConnections()
+		h.client = nil
+	}
+}
+
+// abort discards the cached HTTP client and closes all of its connections, failing the requests in flight
+func (h *httpClientCache) abort() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client != nil {
+		h.client.CloseIdleConnections()
+		h.conns.closeAll()
+		h.client = nil
+	}
+}
+
+// dial opens a connection and tracks it until it is closed
+func (s *connSet) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	s.conns[conn] = struct{}{}
+	return &trackedConn{Conn: conn, set: s}, nil
+}
+
+// closeAll closes every tracked connection, as well as connections still being dialed
+func (s *connSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+// Close closes the connection and stops tracking it
+func (c *trackedConn) Close() error {
+	c.set.mu.Lock()
+	delete(c.set.conns, c.Conn)
+	c.set.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// Recycle discards the HTTP client of the PEClient, the next request opens new connections
+func (c *PEClient) Recycle() {
+	c.httpClient.recycle()
+}
+
+// Recycle discards the HTTP client of the PCClient, the next request opens new connections
+func (c *PCClient) Recycle() {
+	c.httpClient.recycle()
+}
+
+// Abort discards the HTTP client of the PEClient and closes its connections, including those in use
+func (c *PEClient) Abort() {
+	c.httpClient.abort()
+}
+
+// Abort discards the HTTP client of the PCClient and closes its connections, including those in use
+func (c *PCClient) Abort() {
+	c.httpClient.abort()
 }
 
 // Refreshes stale credentials using client methods
-func (c *Cluster) RefreshCredentialsIfNeeded(vaultClient *auth.VaultClient) {
+// Once the re-login interval has passed, credentials are refreshed and the HTTP client is discarded
+// so that no session outlives the interval
+func (c *Cluster) RefreshCredentialsIfNeeded() {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 
-	if c.RefreshNeeded {
-		if err := c.API.RefreshCredentials(vaultClient); err != nil {
+	interval := time.Duration(reloginInterval.Load())
+	relogin := interval > 0 && time.Since(c.loggedInAt) >= interval
+	if c.RefreshNeeded || relogin {
+		if err := c.Credentials.RefreshIfNeeded(); err != nil {
+			log.Printf("Failed to refresh credential provider for cluster %s: %v", c.Name, err)
+			return
+		}
+		username, password, err := c.Credentials.GetCredentials(c.Name)
+		if username == "" || password == "" {
 			log.Printf("Failed to refresh credentials for cluster %s: %v", c.Name, err)
 			return
 		}
+		c.API.SetCredentials(username, password)
 		c.RefreshNeeded = false // Reset the flag after refreshing
+		c.loggedInAt = time.Now()
+		if relogin {
+			c.API.Recycle()
+			log.Printf("Forced re-login for cluster %s", c.Name)
+			return
+		}
 		log.Printf("Credentials refreshed for cluster %s", c.Name)
 	}
 }
 
-// RefreshCredentials refreshes the credentials for the PEClient
-func (c *PEClient) RefreshCredentials(vaultClient *auth.VaultClient) error {
-	username, password, err := vaultClient.GetPECreds(c.URL)
-	if username == "" || password == "" {
-		return fmt.Errorf("failed to refresh credentials for PE client %s: %v", c.URL, err)
-	}
-	c.Username = username
-	c.Password = password
-	return nil
+// SetCredentials replaces the credentials of the PEClient
+// Safe to call while requests are created
+func (c *PEClient) SetCredentials(username, password string) {
+	c.credentials.Store(&credentials{username: username, password: password})
 }
 
-// RefreshCredentials refreshes the credentials for the PCClient
-func (c *PCClient) RefreshCredentials(vaultClient *auth.VaultClient) error {
-	username, password, err := vaultClient.GetPCCreds(c.URL)
-	if username == "" || password == "" {
-		return fmt.Errorf("failed to refresh credentials for PC client %s: %v", c.URL, err)
-	}
-	c.Username = username
-	c.Password = password
-	return nil
+// SetCredentials replaces the credentials of the PCClient
+// Safe to call while requests are created
+func (c *PCClient) SetCredentials(username, password string) {
+	c.credentials.Store(&credentials{username: username, password: password})
 }
 
 // CreateRequest takes context, request type, action, and request parameters
@@ -195,7 +398,8 @@ func (c *PEClient) CreateRequest(ctx context.Context, reqType, action string, p
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.SetBasicAuth(c.Username, c.Password)
+	creds := c.credentials.Load()
+	req.SetBasicAuth(creds.username, creds.password)
 	return req, nil
 }
 
@@ -229,7 +433,8 @@ func (c *PCClient) CreateRequest(ctx context.Context, reqType, action string, p
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.SetBasicAuth(c.Username, c.Password)
+	creds := c.credentials.Load()
+	req.SetBasicAuth(creds.username, creds.password)
 	return req, nil
 }
 
@@ -241,14 +446,8 @@ func (c *PEClient) MakeRequestWithParams(ctx context.Context, reqType, action st
 		return nil, err
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.SkipTLSVerify},
-		},
-		Timeout: c.Timeout,
-	}
-
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.get(c.SkipTLSVerify, c.Timeout).Do(req)
+	recordRequest(c.Cluster, c.ClusterUUID, action, resp)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -264,14 +463,8 @@ func (c *PCClient) MakeRequestWithParams(ctx context.Context, reqType, action st
 		return nil, err
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.SkipTLSVerify},
-		},
-		Timeout: c.Timeout,
-	}
-
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.get(c.SkipTLSVerify, c.Timeout).Do(req)
+	recordRequest(c.Cluster, c.ClusterUUID, action, resp)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nutanix
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// APIRequests counts the requests made to the Nutanix APIs, registered in the exporter's telemetry registry
+var APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nutanix_exporter_api_requests_total",
+	Help: "Number of Nutanix API requests by cluster, endpoint and HTTP status code, or \"error\" if no response was received.",
+}, []string{"cluster_name", "cluster_uuid", "endpoint", "code"})
+
+// recordRequest counts an API request, resp is nil if the request failed without a response
+// The query string is dropped from the endpoint to bound the cardinality
+func recordRequest(cluster, clusterUUID, action string, resp *http.Response) {
+	endpoint, _, _ := strings.Cut(action, "?")
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	APIRequests.WithLabelValues(cluster, clusterUUID, endpoint, code).Inc()
+}
@@ -23,6 +23,7 @@ import (
 
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -33,24 +34,37 @@ import (
 )
 
 // MetricConfig represents one metric in the config file
+// Entries with labels are info metrics, exposed with a constant value of 1 and
+// the listed response keys as label values
 type MetricConfig struct {
-	Name string `yaml:"name"`
-	Help string `yaml:"help"`
+	Name   string            `yaml:"name"`
+	Help   string            `yaml:"help"`
+	Labels map[string]string `yaml:"labels"` // Label name -> key in the API response
+}
+
+// InfoMetric is a constant gauge carrying string values from the API response as labels
+type InfoMetric struct {
+	Gauge *prometheus.GaugeVec
+	Keys  []string // Normalized response keys, in the same order as the extra labels
 }
 
 // Exporter is the struct that gets extended by all other exporters
 type Exporter struct {
-	Cluster *nutanix.Cluster                // Reference to the parent Cluster struct
-	Metrics map[string]*prometheus.GaugeVec // Holds the metrics defined by the exporter
-	Labels  []string                        // Common labels for the metrics
+	Cluster     *nutanix.Cluster                // Reference to the parent Cluster struct
+	Metrics     map[string]*prometheus.GaugeVec // Holds the metrics defined by the exporter
+	InfoMetrics map[string]*InfoMetric          // Holds the info metrics defined by the exporter
+	Labels      []string                        // Common labels for the metrics
+	LabelKeys   []string                        // Response keys of the entity label values, following the cluster name and UUID
 }
 
 // NewExporter is the constructor for Exporter
 func NewExporter(cluster *nutanix.Cluster, labels []string) *Exporter {
 	return &Exporter{
-		Cluster: cluster,
-		Metrics: make(map[string]*prometheus.GaugeVec),
-		Labels:  labels,
+		Cluster:     cluster,
+		Metrics:     make(map[string]*prometheus.GaugeVec),
+		InfoMetrics: make(map[string]*InfoMetric),
+		Labels:      labels,
+		LabelKeys:   []string{"name"},
 	}
 }
 
@@ -79,6 +93,21 @@ func (e *Exporter) valueToFloat64(value interface{}) float64 {
 	return 0
 }
 
+// valueToString converts given value to a label value
+// Numbers are formatted without exponent so that numeric serials and versions stay readable
+func (e *Exporter) valueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 // normalizeKey normalizes given key to lowercase and replaces . and - with _
 func (e *Exporter) normalizeKey(key string) string {
 	return strings.ToLower(strings.NewReplacer(".", "_", "-", "_", ":", "_").Replace(key))
@@ -109,6 +138,19 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, gaugeVec := range e.Metrics {
 		gaugeVec.Describe(ch)
 	}
+	for _, info := range e.InfoMetrics {
+		info.Gauge.Describe(ch)
+	}
+}
+
+// collectMetrics sends the current value of every metric and info metric to the channel
+func (e *Exporter) collectMetrics(ch chan<- prometheus.Metric) {
+	for _, gaugeVec := range e.Metrics {
+		gaugeVec.Collect(ch)
+	}
+	for _, info := range e.InfoMetrics {
+		info.Gauge.Collect(ch)
+	}
 }
 
 // fetchData makes a GET request to the given path and returns the response body as a map
@@ -118,6 +160,12 @@ func (e *Exporter) fetchData(ctx context.Context, path string) (map[string]inter
 		return nil, fmt.Errorf("skipping %s due to known stale creds", e.Cluster.Name)
 	}
 
+	release, err := e.Cluster.AcquireRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	resp, err := e.Cluster.API.MakeRequest(ctx, "GET", path)
 	if err != nil {
 		return nil, err
@@ -141,6 +189,7 @@ func (e *Exporter) fetchData(ctx context.Context, path string) (map[string]inter
 		log.Printf("Error decoding response body: %v\n", err)
 		return nil, err
 	}
+	e.Cluster.MarkReachable()
 
 	return result, nil
 }
@@ -162,6 +211,10 @@ func (e *Exporter) initMetrics(configPath string, labelNames []string) error {
 	subsystem := strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath))
 
 	for _, m := range metrics {
+		if len(m.Labels) > 0 {
+			e.InfoMetrics[m.Name] = e.newInfoMetric(subsystem, m, labelNames)
+			continue
+		}
 		e.Metrics[m.Name] = prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "nutanix",
@@ -176,8 +229,47 @@ func (e *Exporter) initMetrics(configPath string, labelNames []string) error {
 	return nil
 }
 
+// newInfoMetric creates an info metric with the common labels followed by the configured labels in sorted order
+func (e *Exporter) newInfoMetric(subsystem string, m MetricConfig, labelNames []string) *InfoMetric {
+	infoLabels := make([]string, 0, len(m.Labels))
+	for label := range m.Labels {
+		infoLabels = append(infoLabels, label)
+	}
+	sort.Strings(infoLabels)
+
+	keys := make([]string, len(infoLabels))
+	for i, label := range infoLabels {
+		keys[i] = e.normalizeKey(m.Labels[label])
+	}
+
+	return &InfoMetric{
+		Gauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "nutanix",
+				Subsystem: subsystem,
+				Name:      m.Name,
+				Help:      m.Help,
+			},
+			append(append([]string{}, labelNames...), infoLabels...),
+		),
+		Keys: keys,
+	}
+}
+
+// resetMetrics drops every series of the metrics, so that entities missing from the next update are no longer exported
+func (e *Exporter) resetMetrics() {
+	for _, gaugeVec := range e.Metrics {
+		gaugeVec.Reset()
+	}
+}
+
 // updateMetrics processes the JSON structure for hosts and updates the metrics.
 func (e *Exporter) updateMetrics(data map[string]interface{}) {
+	// Info metrics are rebuilt on every update so that changed values don't leave stale series behind
+	for _, info := range e.InfoMetrics {
+		info.Gauge.Reset()
+	}
+
 	// Check if metadata exists and process it
 	if metadata, ok := data["metadata"].(map[string]interface{}); ok {
 		e.processMetadata(metadata)
@@ -204,41 +296,59 @@ func (e *Exporter) processEntity(ent map[string]interface{}, isCluster bool) {
 	// Flatten the map (recursively) to get a flat map with nested keys separated by underscores
 	flatEntity := e.flattenMap("", ent)
 
+	// Set label values for the metrics of this entity
+	var labelValues []string
+	if isCluster {
+		// cluster name and UUID are the only labels for cluster-level metrics
+		labelValues = []string{e.Cluster.Name, e.Cluster.UUID}
+	} else {
+		// For entity-level metrics, use the cluster name and UUID and the entity's label keys, usually its name, as labels
+		labelValues = []string{e.Cluster.Name, e.Cluster.UUID}
+		for _, key := range e.LabelKeys {
+			if value, ok := ent[key].(string); ok {
+				labelValues = append(labelValues, value)
+			} else {
+				// Handle case where the key is missing or not a string
+				labelValues = append(labelValues, "unknown")
+			}
+		}
+	}
+
 	// Iterate over the flattened map and update the metrics
+	normEntity := make(map[string]interface{}, len(flatEntity))
 	for key, value := range flatEntity {
 		// Normalize the key and check if we're collecting this metric
 		normKey := e.normalizeKey(key)
+		normEntity[normKey] = value
 		if g, exists := e.Metrics[normKey]; exists {
-			// Set label values and update the metric
-			var labelValues []string
-
-			if isCluster {
-				// clustername is the only label for cluster-level metrics
-				labelValues = []string{e.Cluster.Name}
-			} else {
-				// For entity-level metrics, use both cluster name and entity name as labels
-				if name, ok := ent["name"].(string); ok {
-					labelValues = []string{e.Cluster.Name, name}
-				} else {
-					// Handle case where "name" is missing or not a string
-					labelValues = []string{e.Cluster.Name, "unknown"}
-				}
-			}
 			g.WithLabelValues(labelValues...).Set(e.valueToFloat64(value))
 		}
 	}
+
+	// Info metrics take their extra label values from the response and are always 1
+	for _, info := range e.InfoMetrics {
+		infoValues := append([]string{}, labelValues...)
+		for _, key := range info.Keys {
+			infoValues = append(infoValues, e.valueToString(normEntity[key]))
+		}
+		info.Gauge.WithLabelValues(infoValues...).Set(1)
+	}
 }
 
 // processMetadata handles the processing of metadata for responses that contain an entity list
 func (e *Exporter) processMetadata(metadata map[string]interface{}) {
 	// Flatten the map (recursively) to get a flat map with nested keys separated by underscores
 	flatMetadata := e.flattenMap("", metadata)
+	labelValues := []string{e.Cluster.Name, e.Cluster.UUID}
+	for range e.LabelKeys {
+		labelValues = append(labelValues, "N/A")
+	}
 	for key, value := range flatMetadata {
 		// Normalize the key and check if we're collecting this metric
 		normKey := e.normalizeKey(key)
 		if g, exists := e.Metrics[normKey]; exists {
 			// Set label values and update the metric
-			g.WithLabelValues(e.Cluster.Name, "N/A").Set(e.valueToFloat64(value))
+			g.WithLabelValues(labelValues...).Set(e.valueToFloat64(value))
 		}
 	}
 }
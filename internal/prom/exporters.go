@@ -16,8 +16,8 @@ limitations under the License.
 package prom
 
 import (
-	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
@@ -39,14 +39,37 @@ type VmExporter struct {
 	*Exporter
 }
 
+type VmDiskExporter struct {
+	*Exporter
+}
+
 type StorageContainerExporter struct {
 	*Exporter
 }
 
+type AlertExporter struct {
+	*Exporter
+	Count *prometheus.GaugeVec // Unresolved alerts per severity
+}
+
+type DiskExporter struct {
+	*Exporter
+}
+
+type ProtectionDomainExporter struct {
+	*Exporter
+	LastSnapshot *prometheus.GaugeVec // Creation time of the latest snapshot per protection domain
+	SnapshotAge  *prometheus.GaugeVec // Time since the latest snapshot per protection domain
+	LagSeconds   *prometheus.GaugeVec // Time since the latest replicated snapshot per protection domain and remote site
+}
+
+// alertSeverities are always exposed by the alert count, so that alerting rules see 0 instead of no data
+var alertSeverities = []string{"critical", "warning", "info"}
+
 // ----- Constructors ----- //
 
 func NewClusterCollector(cluster *nutanix.Cluster, configPath string) *ClusterExporter {
-	labels := []string{"cluster_name"}
+	labels := []string{"cluster_name", "cluster_uuid"}
 	exporter := &ClusterExporter{
 		Exporter: NewExporter(cluster, labels),
 	}
@@ -55,7 +78,7 @@ func NewClusterCollector(cluster *nutanix.Cluster, configPath string) *ClusterEx
 }
 
 func NewHostCollector(cluster *nutanix.Cluster, configPath string) *HostsExporter {
-	labels := []string{"cluster_name", "host_name"}
+	labels := []string{"cluster_name", "cluster_uuid", "host_name"}
 	exporter := &HostsExporter{
 		Exporter: NewExporter(cluster, labels),
 	}
@@ -64,7 +87,7 @@ func NewHostCollector(cluster *nutanix.Cluster, configPath string) *HostsExporte
 }
 
 func NewVMCollector(cluster *nutanix.Cluster, configPath string) *VmExporter {
-	labels := []string{"cluster_name", "vm_name"}
+	labels := []string{"cluster_name", "cluster_uuid", "vm_name"}
 	exporter := &VmExporter{
 		Exporter: NewExporter(cluster, labels),
 	}
@@ -72,8 +95,19 @@ func NewVMCollector(cluster *nutanix.Cluster, configPath string) *VmExporter {
 	return exporter
 }
 
+// NewVMDiskCollector collects one series per virtual disk, so it is optional due to cardinality
+func NewVMDiskCollector(cluster *nutanix.Cluster, configPath string) *VmDiskExporter {
+	labels := []string{"cluster_name", "cluster_uuid", "vm_name", "vdisk_uuid", "disk_address"}
+	exporter := &VmDiskExporter{
+		Exporter: NewExporter(cluster, labels),
+	}
+	exporter.LabelKeys = []string{"attached_vmname", "uuid", "disk_address"}
+	exporter.initMetrics(configPath, labels)
+	return exporter
+}
+
 func NewStorageContainerCollector(cluster *nutanix.Cluster, configPath string) *StorageContainerExporter {
-	labels := []string{"cluster_name", "container_name"}
+	labels := []string{"cluster_name", "cluster_uuid", "container_name"}
 	exporter := &StorageContainerExporter{
 		Exporter: NewExporter(cluster, labels),
 	}
@@ -81,12 +115,67 @@ func NewStorageContainerCollector(cluster *nutanix.Cluster, configPath string) *
 	return exporter
 }
 
+// NewAlertCollector collects the unresolved alerts of the cluster
+func NewAlertCollector(cluster *nutanix.Cluster, configPath string) *AlertExporter {
+	labels := []string{"cluster_name", "cluster_uuid", "alert_id"}
+	exporter := &AlertExporter{
+		Exporter: NewExporter(cluster, labels),
+		Count: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nutanix",
+			Subsystem: "alert",
+			Name:      "count",
+			Help:      "Number of unresolved alerts by severity.",
+		}, []string{"cluster_name", "cluster_uuid", "severity"}),
+	}
+	exporter.LabelKeys = []string{"id"}
+	exporter.initMetrics(configPath, labels)
+	return exporter
+}
+
+func NewDiskCollector(cluster *nutanix.Cluster, configPath string) *DiskExporter {
+	labels := []string{"cluster_name", "cluster_uuid", "host_name", "disk_uuid"}
+	exporter := &DiskExporter{
+		Exporter: NewExporter(cluster, labels),
+	}
+	exporter.LabelKeys = []string{"host_name", "disk_uuid"}
+	exporter.initMetrics(configPath, labels)
+	return exporter
+}
+
+// NewProtectionDomainCollector collects the protection domains and the age of their latest snapshot
+func NewProtectionDomainCollector(cluster *nutanix.Cluster, configPath string) *ProtectionDomainExporter {
+	labels := []string{"cluster_name", "cluster_uuid", "protection_domain"}
+	exporter := &ProtectionDomainExporter{
+		Exporter: NewExporter(cluster, labels),
+		LastSnapshot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nutanix",
+			Subsystem: "protection_domain",
+			Name:      "last_snapshot_timestamp_seconds",
+			Help:      "Creation time of the latest snapshot of the protection domain in seconds since the epoch.",
+		}, labels),
+		SnapshotAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nutanix",
+			Subsystem: "protection_domain",
+			Name:      "last_snapshot_age_seconds",
+			Help:      "Time since the latest snapshot of the protection domain in seconds.",
+		}, labels),
+		LagSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nutanix",
+			Subsystem: "protection_domain",
+			Name:      "replication_lag_seconds",
+			Help:      "Time since the latest snapshot of the protection domain that was replicated to the remote site in seconds.",
+		}, append(labels, "remote_site")),
+	}
+	exporter.initMetrics(configPath, labels)
+	return exporter
+}
+
 // ----- Collect Methods ----- //
 
 // Collect
 func (e *StorageContainerExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "storage_container", e.Cluster.Timeout)
+	defer done()
 
 	result, err := e.fetchData(ctx, "/v2.0/storage_containers/")
 	if err != nil {
@@ -96,15 +185,13 @@ func (e *StorageContainerExporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.updateMetrics(result)
 
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+	e.collectMetrics(ch)
 }
 
 // Collect
 func (e *ClusterExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "cluster", e.Cluster.Timeout)
+	defer done()
 
 	result, err := e.fetchData(ctx, "/v2.0/cluster/")
 	if err != nil {
@@ -114,15 +201,13 @@ func (e *ClusterExporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.updateMetrics(result)
 
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+	e.collectMetrics(ch)
 }
 
 // Collect
 func (e *HostsExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "host", e.Cluster.Timeout)
+	defer done()
 
 	result, err := e.fetchData(ctx, "/v2.0/hosts/")
 	if err != nil {
@@ -132,15 +217,13 @@ func (e *HostsExporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.updateMetrics(result)
 
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
-	}
+	e.collectMetrics(ch)
 }
 
 // Collect
 func (e *VmExporter) Collect(ch chan<- prometheus.Metric) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "vm", e.Cluster.Timeout)
+	defer done()
 
 	result, err := e.fetchData(ctx, "/v2.0/vms/")
 	if err != nil {
@@ -150,7 +233,163 @@ func (e *VmExporter) Collect(ch chan<- prometheus.Metric) {
 
 	e.updateMetrics(result)
 
-	for _, gaugeVec := range e.Metrics {
-		gaugeVec.Collect(ch)
+	e.collectMetrics(ch)
+}
+
+// Collect
+func (e *VmDiskExporter) Collect(ch chan<- prometheus.Metric) {
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "vm_disk", e.Cluster.Timeout)
+	defer done()
+
+	result, err := e.fetchData(ctx, "/v2.0/virtual_disks/")
+	if err != nil {
+		log.Printf("Error fetching virtual disk data: %v", err)
+		return
+	}
+
+	// Deleted and migrated virtual disks must not leave stale series behind
+	e.resetMetrics()
+	e.updateMetrics(result)
+
+	e.collectMetrics(ch)
+}
+
+// Describe adds the alert count to the metrics of the config file
+func (e *AlertExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.Exporter.Describe(ch)
+	e.Count.Describe(ch)
+}
+
+// Collect
+// The API lists resolved alerts as well, only unresolved ones are exposed
+func (e *AlertExporter) Collect(ch chan<- prometheus.Metric) {
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "alert", e.Cluster.Timeout)
+	defer done()
+
+	result, err := e.fetchData(ctx, "/v2.0/alerts/")
+	if err != nil {
+		log.Printf("Error fetching alert data: %v", err)
+		return
+	}
+
+	counts := make(map[string]int)
+	unresolved := []interface{}{}
+	entities, _ := result["entities"].([]interface{})
+	for _, entity := range entities {
+		ent, ok := entity.(map[string]interface{})
+		if !ok || ent["resolved"] == true {
+			continue
+		}
+		// Severities are returned as kCritical, kWarning and kInfo
+		severity, _ := ent["severity"].(string)
+		severity = strings.ToLower(strings.TrimPrefix(severity, "k"))
+		ent["severity"] = severity
+		counts[severity]++
+		unresolved = append(unresolved, ent)
+	}
+
+	// Resolved alerts must not leave stale series behind
+	e.resetMetrics()
+	e.updateMetrics(map[string]interface{}{"entities": unresolved})
+
+	e.Count.Reset()
+	for _, severity := range alertSeverities {
+		e.Count.WithLabelValues(e.Cluster.Name, e.Cluster.UUID, severity).Set(0)
 	}
+	for severity, count := range counts {
+		e.Count.WithLabelValues(e.Cluster.Name, e.Cluster.UUID, severity).Set(float64(count))
+	}
+
+	e.collectMetrics(ch)
+	e.Count.Collect(ch)
+}
+
+// Collect
+func (e *DiskExporter) Collect(ch chan<- prometheus.Metric) {
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "disk", e.Cluster.Timeout)
+	defer done()
+
+	result, err := e.fetchData(ctx, "/v2.0/disks/")
+	if err != nil {
+		log.Printf("Error fetching disk data: %v", err)
+		return
+	}
+
+	// Removed and replaced disks must not leave stale series behind
+	e.resetMetrics()
+	e.updateMetrics(result)
+
+	e.collectMetrics(ch)
+}
+
+// Describe adds the snapshot metrics to the metrics of the config file
+func (e *ProtectionDomainExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.Exporter.Describe(ch)
+	e.LastSnapshot.Describe(ch)
+	e.SnapshotAge.Describe(ch)
+	e.LagSeconds.Describe(ch)
+}
+
+// Collect
+// The latest snapshot of every protection domain is taken from the list of its snapshots,
+// the replication lag from the latest of them listed on each remote site
+func (e *ProtectionDomainExporter) Collect(ch chan<- prometheus.Metric) {
+	ctx, done := CollectionWatchdog.Track(e.Cluster, "protection_domain", e.Cluster.Timeout)
+	defer done()
+
+	result, err := e.fetchData(ctx, "/v2.0/protection_domains/")
+	if err != nil {
+		log.Printf("Error fetching protection domain data: %v", err)
+		return
+	}
+
+	e.updateMetrics(result)
+
+	snapshots, err := e.fetchData(ctx, "/v2.0/protection_domains/dr_snapshots/")
+	if err != nil {
+		log.Printf("Error fetching protection domain snapshot data: %v", err)
+	} else {
+		latest := make(map[string]float64)
+		replicated := make(map[[2]string]float64) // Keyed by protection domain and remote site
+		entities, _ := snapshots["entities"].([]interface{})
+		for _, entity := range entities {
+			ent, ok := entity.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := ent["protection_domain_name"].(string)
+			created := e.valueToFloat64(ent["snapshot_create_time_usecs"]) / 1e6
+			if name == "" {
+				continue
+			}
+			if created > latest[name] {
+				latest[name] = created
+			}
+			remoteSites, _ := ent["remote_site_names"].([]interface{})
+			for _, remoteSite := range remoteSites {
+				site, _ := remoteSite.(string)
+				key := [2]string{name, site}
+				if site != "" && created > replicated[key] {
+					replicated[key] = created
+				}
+			}
+		}
+
+		e.LastSnapshot.Reset()
+		e.SnapshotAge.Reset()
+		e.LagSeconds.Reset()
+		now := float64(time.Now().UnixNano()) / 1e9
+		for name, created := range latest {
+			e.LastSnapshot.WithLabelValues(e.Cluster.Name, e.Cluster.UUID, name).Set(created)
+			e.SnapshotAge.WithLabelValues(e.Cluster.Name, e.Cluster.UUID, name).Set(now - created)
+		}
+		for key, created := range replicated {
+			e.LagSeconds.WithLabelValues(e.Cluster.Name, e.Cluster.UUID, key[0], key[1]).Set(now - created)
+		}
+	}
+
+	e.collectMetrics(ch)
+	e.LastSnapshot.Collect(ch)
+	e.SnapshotAge.Collect(ch)
+	e.LagSeconds.Collect(ch)
 }
@@ -0,0 +1,66 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RecoveringCollector wraps a collector so that a panic in Collect is logged and counted
+// instead of crashing the exporter. The registry runs Collect in its own goroutine, so the
+// panic has to be recovered inside Collect itself.
+type RecoveringCollector struct {
+	prometheus.Collector
+	Cluster *nutanix.Cluster
+	Name    string
+}
+
+// WithRecovery wraps the collector with panic recovery
+func WithRecovery(cluster *nutanix.Cluster, name string, collector prometheus.Collector) *RecoveringCollector {
+	return &RecoveringCollector{
+		Collector: collector,
+		Cluster:   cluster,
+		Name:      name,
+	}
+}
+
+// Collect calls the wrapped collector, records its duration and recovers from any panic
+func (c *RecoveringCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	defer func() {
+		ScrapeDuration.WithLabelValues(c.Cluster.Name, c.Cluster.UUID, c.Name).Set(time.Since(start).Seconds())
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			Stats.Inc(CollectorPanicsDesc, c.Cluster, c.Name)
+			slog.Error("Recovered from panic in collector",
+				"cluster", c.Cluster.Name,
+				"collector", c.Name,
+				"panic", fmt.Sprint(r),
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+
+	c.Collector.Collect(ch)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"sync"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	StuckCollectionsDesc = prometheus.NewDesc(
+		"nutanix_exporter_stuck_collections_total",
+		"Number of collections the watchdog found still running far beyond their deadline.",
+		[]string{"cluster_name", "cluster_uuid", "collector"}, nil,
+	)
+	CollectorPanicsDesc = prometheus.NewDesc(
+		"nutanix_exporter_collector_panics_total",
+		"Number of panics recovered from collectors.",
+		[]string{"cluster_name", "cluster_uuid", "collector"}, nil,
+	)
+
+	statsDescs = []*prometheus.Desc{StuckCollectionsDesc, CollectorPanicsDesc}
+
+	// ScrapeDuration is registered in the exporter's telemetry registry
+	ScrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "nutanix_exporter_scrape_duration_seconds",
+		Help: "Duration of the last collection of each cluster and collector in seconds.",
+	}, []string{"cluster_name", "cluster_uuid", "collector"})
+)
+
+// statKey identifies one counter series, clusters are identified by their ID so counters survive renames
+type statKey struct {
+	desc      *prometheus.Desc
+	cluster   string
+	collector string
+}
+
+// Stats holds the exporter's own per-cluster, per-collector counters
+// They live outside the cluster registries so they survive cluster list refreshes
+var Stats = &ExporterStats{counters: make(map[statKey]float64)}
+
+// ExporterStats is a set of counters keyed by metric, cluster and collector
+type ExporterStats struct {
+	mu       sync.Mutex
+	counters map[statKey]float64
+}
+
+// Inc increments the counter of the given metric for a cluster and collector
+func (s *ExporterStats) Inc(desc *prometheus.Desc, cluster *nutanix.Cluster, collector string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[statKey{desc: desc, cluster: cluster.ID(), collector: collector}]++
+}
+
+// Forget deletes the counters of the cluster with the given ID
+func (s *ExporterStats) Forget(clusterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.counters {
+		if key.cluster == clusterID {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// StatsCollector exposes the exporter's own counters for one cluster
+type StatsCollector struct {
+	Cluster *nutanix.Cluster
+}
+
+// NewStatsCollector is the constructor for StatsCollector
+func NewStatsCollector(cluster *nutanix.Cluster) *StatsCollector {
+	return &StatsCollector{Cluster: cluster}
+}
+
+// Describe method required by prometheus.Collector interface
+func (c *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range statsDescs {
+		ch <- desc
+	}
+}
+
+// Collect sends the counters belonging to the cluster
+func (c *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	Stats.mu.Lock()
+	defer Stats.mu.Unlock()
+
+	for key, value := range Stats.counters {
+		if key.cluster != c.Cluster.ID() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(key.desc, prometheus.CounterValue, value, c.Cluster.Name, c.Cluster.UUID, key.collector)
+	}
+}
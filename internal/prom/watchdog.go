@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Ingka Holding B.V. All Rights Reserved.
+Licensed under the GPL, Version 2 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+       <https://www.gnu.org/licenses/gpl-2.0.en.html>
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prom
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ingka-group/nutanix-exporter/internal/nutanix"
+)
+
+const DefaultWatchdogGrace = 30 * time.Second
+
+// CollectionWatchdog tracks the collections of all collectors
+var CollectionWatchdog = NewWatchdog(DefaultWatchdogGrace, false)
+
+// Watchdog detects collections that keep running far beyond their deadline
+// Collections are cancelled by their context at the deadline. A collection still running after the grace
+// period did not respond to the cancellation, e.g. because it is blocked on a hung TCP connection.
+type Watchdog struct {
+	mu            sync.Mutex
+	grace         time.Duration // How far beyond its deadline a collection may run
+	recycleClient bool          // Whether to discard the cluster's HTTP client and close its connections when cancelling doesn't help
+	active        map[*collection]struct{}
+}
+
+// collection is one running Collect call
+type collection struct {
+	cluster   *nutanix.Cluster
+	collector string
+	deadline  time.Time
+	cancel    context.CancelFunc
+	reported  bool // Whether the collection was reported as stuck and its context cancelled
+	aborted   bool // Whether the connections of the cluster were closed for the collection
+}
+
+// NewWatchdog is the constructor for Watchdog
+func NewWatchdog(grace time.Duration, recycleClient bool) *Watchdog {
+	return &Watchdog{
+		grace:         grace,
+		recycleClient: recycleClient,
+		active:        make(map[*collection]struct{}),
+	}
+}
+
+// Configure changes the grace period and whether collections that stay stuck recycle the HTTP client
+// Safe to call while the watchdog is running
+func (w *Watchdog) Configure(grace time.Duration, recycleClient bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.grace = grace
+	w.recycleClient = recycleClient
+}
+
+// Track returns a context with the given timeout for a collection and tracks it until done is called
+func (w *Watchdog) Track(cluster *nutanix.Cluster, collector string, timeout time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	c := &collection{
+		cluster:   cluster,
+		collector: collector,
+		deadline:  time.Now().Add(timeout),
+		cancel:    cancel,
+	}
+
+	w.mu.Lock()
+	w.active[c] = struct{}{}
+	w.mu.Unlock()
+
+	return ctx, func() {
+		w.mu.Lock()
+		delete(w.active, c)
+		w.mu.Unlock()
+		cancel()
+	}
+}
+
+// Run checks the tracked collections every interval until the context is cancelled
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check reports and cancels every collection that has been running longer than its deadline plus the grace period
+// With RecycleClient, a collection still running at the next check did not respond to its own cancellation either,
+// so the connections of its cluster are closed as a last resort, which unblocks a collection stuck on one of them
+func (w *Watchdog) check() {
+	w.mu.Lock()
+	recycleClient := w.recycleClient
+	var stuck, stillStuck []*collection
+	for c := range w.active {
+		switch {
+		case !c.reported && time.Since(c.deadline) > w.grace:
+			c.reported = true
+			stuck = append(stuck, c)
+		case c.reported && !c.aborted && recycleClient:
+			c.aborted = true
+			stillStuck = append(stillStuck, c)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, c := range stuck {
+		log.Printf("Watchdog: %s collection for cluster %s is still running %s past its deadline, cancelling it",
+			c.collector, c.cluster.Name, time.Since(c.deadline).Round(time.Second))
+		Stats.Inc(StuckCollectionsDesc, c.cluster, c.collector)
+		c.cancel()
+	}
+
+	for _, c := range stillStuck {
+		log.Printf("Watchdog: %s collection for cluster %s ignored its cancellation, closing the connections of the cluster",
+			c.collector, c.cluster.Name)
+		c.cluster.API.Abort()
+	}
+}